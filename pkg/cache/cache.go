@@ -3,6 +3,8 @@ package cache
 import (
 	"context"
 	"errors"
+	"fmt"
+	"reflect"
 	"time"
 )
 
@@ -22,3 +24,66 @@ type Cache interface {
 	// Delete value from cache
 	Del(ctx context.Context, key ...string) error
 }
+
+// Extended cache interface, provides batch and atomic operations
+type ExtendedCache interface {
+	Cache
+
+	// Get multiple values, out must be a pointer to map[string]T, missing keys are absent in it
+	MGet(ctx context.Context, keys []string, out interface{}) error
+
+	// Set multiple values with the same expiration
+	MSet(ctx context.Context, values map[string]interface{}, expiration time.Duration) error
+
+	// Count existing keys
+	Exists(ctx context.Context, keys ...string) (int64, error)
+
+	// Get remaining time to live, zero means never expire. ErrNil is returned if key does not exist
+	TTL(ctx context.Context, key string) (time.Duration, error)
+
+	// Set expiration, zero means never expire. ErrNil is returned if key does not exist
+	Expire(ctx context.Context, key string, expiration time.Duration) error
+
+	// Increase integer value by delta, the value is initialized as zero if key does not exist
+	Incr(ctx context.Context, key string, delta int64) (int64, error)
+
+	// Decrease integer value by delta, the value is initialized as zero if key does not exist
+	Decr(ctx context.Context, key string, delta int64) (int64, error)
+
+	// Set value only if key does not exist, returns whether the value is set
+	SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error)
+}
+
+// Fill the output map of MGet, out must be a pointer to map[string]T
+func FillMap(out interface{}, values map[string][]byte, decode func(data []byte, v interface{}) error) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Map || rv.Elem().Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("[cache] Output must be a pointer to map[string]T: %T", out)
+	}
+	m := rv.Elem()
+	if m.IsNil() {
+		m.Set(reflect.MakeMap(m.Type()))
+	}
+	for k, data := range values {
+		v := reflect.New(m.Type().Elem())
+		if err := decode(data, v.Interface()); err != nil {
+			return err
+		}
+		m.SetMapIndex(reflect.ValueOf(k).Convert(m.Type().Key()), v.Elem())
+	}
+	return nil
+}
+
+// Tagged cache interface, provides tag and prefix based invalidation
+type TaggedCache interface {
+	Cache
+
+	// Set value to cache with tags
+	SetWithTags(ctx context.Context, key string, value interface{}, expiration time.Duration, tags ...string) error
+
+	// Delete all values with any of the tags
+	InvalidateTags(ctx context.Context, tags ...string) error
+
+	// Delete all values whose keys start with the prefix
+	DelByPrefix(ctx context.Context, prefix string) error
+}
@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec serializes cache values
+type Codec interface {
+	// Codec id written in the header byte, must be in range [1, 15]
+	Id() byte
+
+	// Marshal value
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal data into value
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Id() byte                                   { return 1 }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) Id() byte { return 2 }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Id() byte                                   { return 3 }
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+
+var (
+	JSONCodec    Codec = jsonCodec{}
+	GobCodec     Codec = gobCodec{}
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+var codecs = map[byte]Codec{
+	JSONCodec.Id():    JSONCodec,
+	GobCodec.Id():     GobCodec,
+	MsgpackCodec.Id(): MsgpackCodec,
+}
+
+var codecsLock = new(sync.RWMutex)
+
+// Register a custom codec, so that values encoded by it can be decoded
+func RegisterCodec(c Codec) {
+	if c.Id() == 0 || c.Id() > 0x0f {
+		panic(fmt.Sprintf("[cache] Invalid codec id: %d", c.Id()))
+	}
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	codecs[c.Id()] = c
+}
+
+// Compression algorithm
+type Compression byte
+
+const (
+	NoCompression Compression = iota
+	Gzip
+	Snappy
+)
+
+var ErrInvalidHeader = errors.New("invalid cache value header")
+
+// Header byte layout: 1 bit flag (always 1) | 1 bit reserved | 2 bits compression | 4 bits codec id.
+// Legacy values are raw JSON, whose first byte is always less than 0x80.
+const headerFlag byte = 0x80
+
+// Encoder encodes values with a header byte which identifies the codec and compression
+type Encoder struct {
+	// Codec of values
+	Codec Codec
+	// Compression algorithm of values
+	Compression Compression
+	// Values smaller than threshold are not compressed
+	Threshold int
+}
+
+// Encode value
+func (e *Encoder) Encode(v interface{}) ([]byte, error) {
+	data, err := e.Codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	comp := NoCompression
+	if e.Compression != NoCompression && len(data) >= e.Threshold {
+		comp = e.Compression
+		if data, err = compress(comp, data); err != nil {
+			return nil, err
+		}
+	}
+	out := make([]byte, 0, len(data)+1)
+	out = append(out, headerFlag|byte(comp)<<4|e.Codec.Id())
+	return append(out, data...), nil
+}
+
+// Decode value encoded by any registered codec, or raw JSON value without header
+func Decode(data []byte, v interface{}) error {
+	if len(data) == 0 || data[0]&headerFlag == 0 {
+		return json.Unmarshal(data, v)
+	}
+	codecsLock.RLock()
+	c, ok := codecs[data[0]&0x0f]
+	codecsLock.RUnlock()
+	if !ok {
+		return ErrInvalidHeader
+	}
+	payload, err := decompress(Compression(data[0]>>4&0x03), data[1:])
+	if err != nil {
+		return err
+	}
+	return c.Unmarshal(payload, v)
+}
+
+func compress(comp Compression, data []byte) ([]byte, error) {
+	switch comp {
+	case Gzip:
+		buf := new(bytes.Buffer)
+		w := gzip.NewWriter(buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Snappy:
+		return snappy.Encode(nil, data), nil
+	default:
+		return nil, fmt.Errorf("[cache] Unsupported compression: %d", comp)
+	}
+}
+
+func decompress(comp Compression, data []byte) ([]byte, error) {
+	switch comp {
+	case NoCompression:
+		return data, nil
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case Snappy:
+		return snappy.Decode(nil, data)
+	default:
+		return nil, ErrInvalidHeader
+	}
+}
@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+type codecValue struct {
+	Name string
+	Time time.Time
+	Big  *big.Int
+}
+
+func TestCodecs(t *testing.T) {
+	in := codecValue{
+		Name: strings.Repeat("test", 100),
+		Time: time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+		Big:  new(big.Int).Lsh(big.NewInt(1), 100),
+	}
+	for _, codec := range []Codec{JSONCodec, GobCodec, MsgpackCodec} {
+		for _, comp := range []Compression{NoCompression, Gzip, Snappy} {
+			e := &Encoder{Codec: codec, Compression: comp}
+			data, err := e.Encode(in)
+			if err != nil {
+				t.Fatalf("no error expected for Encode with codec %d and compression %d but got '%v'", codec.Id(), comp, err)
+			}
+			if data[0] != headerFlag|byte(comp)<<4|codec.Id() {
+				t.Errorf("invalid header 0x%x for codec %d and compression %d", data[0], codec.Id(), comp)
+			}
+			out := codecValue{}
+			if err := Decode(data, &out); err != nil {
+				t.Fatalf("no error expected for Decode with codec %d and compression %d but got '%v'", codec.Id(), comp, err)
+			}
+			if out.Name != in.Name || !out.Time.Equal(in.Time) || out.Big.Cmp(in.Big) != 0 {
+				t.Errorf("decoded value mismatch for codec %d and compression %d", codec.Id(), comp)
+			}
+		}
+	}
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	e := &Encoder{Codec: JSONCodec, Compression: Gzip, Threshold: 100}
+	data, _ := e.Encode("hello")
+	if data[0] != headerFlag|JSONCodec.Id() {
+		t.Errorf("small value should not be compressed but got header 0x%x", data[0])
+	}
+	data, _ = e.Encode(strings.Repeat("hello", 100))
+	if data[0] != headerFlag|byte(Gzip)<<4|JSONCodec.Id() {
+		t.Errorf("large value should be compressed but got header 0x%x", data[0])
+	}
+}
+
+func TestDecodeLegacyJSON(t *testing.T) {
+	out := []string{}
+	if err := Decode([]byte(`["a","b"]`), &out); err != nil {
+		t.Errorf("no error expected for Decode raw JSON but got '%v'", err)
+	}
+	if len(out) != 2 || out[0] != "a" || out[1] != "b" {
+		t.Errorf("expected 'a,b' but got '%v'", out)
+	}
+}
+
+func TestDecodeUnknownCodec(t *testing.T) {
+	var out string
+	if err := Decode([]byte{headerFlag | 0x0f, '1'}, &out); err != ErrInvalidHeader {
+		t.Errorf("expected error 'invalid cache value header' but got '%v'", err)
+	}
+}
@@ -0,0 +1,319 @@
+package instrumented
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/cache"
+	"github.com/ofavor/ddd-go/pkg/metrics"
+)
+
+// Metric names
+const (
+	MetricRequests = "cache_requests_total"
+	MetricLatency  = "cache_latency_seconds"
+	MetricPayload  = "cache_payload_bytes"
+)
+
+// Request results
+const (
+	ResultHit   = "hit"
+	ResultMiss  = "miss"
+	ResultOk    = "ok"
+	ResultError = "error"
+)
+
+// Namespace of keys without separator
+const defaultNamespace = "default"
+
+// Instrumented cache option
+type Option func(c *instrumentedCache)
+
+// Measure payload sizes of the given fraction of set and get hits, it is disabled by default.
+// Payloads are encoded again by the codec of cache to be measured, so it costs as much as encoding.
+func WithPayloadSampling(rate float64) Option {
+	return func(c *instrumentedCache) {
+		c.sampling = rate
+	}
+}
+
+// instrumentedCache records requests, latency and payload sizes of the wrapped cache.
+// Metrics are labeled with cache name, operation and key namespace, which is the key part before the first ':'.
+type instrumentedCache struct {
+	cache.Cache
+	name     string
+	metrics  metrics.Metrics
+	sampling float64
+	codec    cache.Codec
+}
+
+// NewCache create instrumented cache, name distinguishes caches sharing the same metrics.
+// The returned cache implements cache.ExtendedCache and cache.TaggedCache if c does,
+// so it can be type asserted like the wrapped cache.
+func NewCache(c cache.Cache, m metrics.Metrics, name string, opts ...Option) cache.Cache {
+	if m == nil {
+		m = metrics.Noop
+	}
+	ic := &instrumentedCache{
+		Cache:   c,
+		name:    name,
+		metrics: m,
+		codec:   cache.JSONCodec,
+	}
+	if p, ok := c.(cache.CodecProvider); ok {
+		ic.codec = p.Codec()
+	}
+	for _, opt := range opts {
+		opt(ic)
+	}
+	ext, isExt := c.(cache.ExtendedCache)
+	tagged, isTagged := c.(cache.TaggedCache)
+	switch {
+	case isExt && isTagged:
+		return &fullCache{ic, &extOps{ic, ext}, &tagOps{ic, tagged}}
+	case isExt:
+		return &extendedCache{ic, &extOps{ic, ext}}
+	case isTagged:
+		return &taggedCache{ic, &tagOps{ic, tagged}}
+	}
+	return ic
+}
+
+// Create instrumented extended cache, see NewCache
+func NewExtendedCache(c cache.ExtendedCache, m metrics.Metrics, name string, opts ...Option) cache.ExtendedCache {
+	return NewCache(c, m, name, opts...).(cache.ExtendedCache)
+}
+
+// Create instrumented tagged cache, see NewCache
+func NewTaggedCache(c cache.TaggedCache, m metrics.Metrics, name string, opts ...Option) cache.TaggedCache {
+	return NewCache(c, m, name, opts...).(cache.TaggedCache)
+}
+
+// Get namespace of key
+func Namespace(key string) string {
+	if i := strings.Index(key, ":"); i > 0 {
+		return key[:i]
+	}
+	return defaultNamespace
+}
+
+func (c *instrumentedCache) labels(op, key string) metrics.Labels {
+	return metrics.Labels{"cache": c.name, "op": op, "namespace": Namespace(key)}
+}
+
+func (c *instrumentedCache) record(op, key, result string, start time.Time) {
+	labels := c.labels(op, key)
+	metrics.ObserveSince(c.metrics, MetricLatency, labels, start)
+	labels["result"] = result
+	c.metrics.IncCounter(MetricRequests, labels, 1)
+}
+
+// Record request of each key with the same result
+func (c *instrumentedCache) recordKeys(op string, keys []string, err error, start time.Time) {
+	result := ResultOk
+	if err != nil {
+		result = ResultError
+	}
+	for _, k := range keys {
+		c.record(op, k, result, start)
+	}
+}
+
+// Record lookup, ErrNil is counted as miss
+func (c *instrumentedCache) recordLookup(op, key string, err error, start time.Time) {
+	switch {
+	case err == nil:
+		c.record(op, key, ResultHit, start)
+	case errors.Is(err, cache.ErrNil):
+		c.record(op, key, ResultMiss, start)
+	default:
+		c.record(op, key, ResultError, start)
+	}
+}
+
+func (c *instrumentedCache) observeSize(op, key string, v interface{}) {
+	if c.sampling <= 0 || rand.Float64() >= c.sampling {
+		return
+	}
+	if data, err := c.codec.Marshal(v); err == nil {
+		c.metrics.ObserveHistogram(MetricPayload, c.labels(op, key), float64(len(data)))
+	}
+}
+
+// Codec implements cache.CodecProvider.
+func (c *instrumentedCache) Codec() cache.Codec {
+	return c.codec
+}
+
+// Set value to cache
+func (c *instrumentedCache) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	start := time.Now()
+	err := c.Cache.Set(ctx, key, value, expiration)
+	if err != nil {
+		c.record("set", key, ResultError, start)
+		return err
+	}
+	c.record("set", key, ResultOk, start)
+	c.observeSize("set", key, value)
+	return nil
+}
+
+// Get value from cache, ErrNil is counted as miss
+func (c *instrumentedCache) Get(ctx context.Context, key string, out interface{}) error {
+	start := time.Now()
+	err := c.Cache.Get(ctx, key, out)
+	c.recordLookup("get", key, err, start)
+	if err == nil {
+		c.observeSize("get", key, out)
+	}
+	return err
+}
+
+// Delete value from cache, the request is recorded once for each key
+func (c *instrumentedCache) Del(ctx context.Context, key ...string) error {
+	start := time.Now()
+	err := c.Cache.Del(ctx, key...)
+	c.recordKeys("del", key, err, start)
+	return err
+}
+
+// Instrumented operations of cache.ExtendedCache
+type extOps struct {
+	c   *instrumentedCache
+	ext cache.ExtendedCache
+}
+
+// MGet implements cache.ExtendedCache, each key is recorded as hit or miss.
+func (o *extOps) MGet(ctx context.Context, keys []string, out interface{}) error {
+	start := time.Now()
+	err := o.ext.MGet(ctx, keys, out)
+	if err != nil {
+		o.c.recordKeys("mget", keys, err, start)
+		return err
+	}
+	m := reflect.ValueOf(out).Elem()
+	for _, k := range keys {
+		result := ResultMiss
+		if m.MapIndex(reflect.ValueOf(k).Convert(m.Type().Key())).IsValid() {
+			result = ResultHit
+		}
+		o.c.record("mget", k, result, start)
+	}
+	return nil
+}
+
+// MSet implements cache.ExtendedCache.
+func (o *extOps) MSet(ctx context.Context, values map[string]interface{}, expiration time.Duration) error {
+	start := time.Now()
+	err := o.ext.MSet(ctx, values, expiration)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	o.c.recordKeys("mset", keys, err, start)
+	return err
+}
+
+// Exists implements cache.ExtendedCache.
+func (o *extOps) Exists(ctx context.Context, keys ...string) (int64, error) {
+	start := time.Now()
+	n, err := o.ext.Exists(ctx, keys...)
+	o.c.recordKeys("exists", keys, err, start)
+	return n, err
+}
+
+// TTL implements cache.ExtendedCache.
+func (o *extOps) TTL(ctx context.Context, key string) (time.Duration, error) {
+	start := time.Now()
+	ttl, err := o.ext.TTL(ctx, key)
+	o.c.recordLookup("ttl", key, err, start)
+	return ttl, err
+}
+
+// Expire implements cache.ExtendedCache.
+func (o *extOps) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	start := time.Now()
+	err := o.ext.Expire(ctx, key, expiration)
+	o.c.recordLookup("expire", key, err, start)
+	return err
+}
+
+// Incr implements cache.ExtendedCache.
+func (o *extOps) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	start := time.Now()
+	n, err := o.ext.Incr(ctx, key, delta)
+	o.c.recordKeys("incr", []string{key}, err, start)
+	return n, err
+}
+
+// Decr implements cache.ExtendedCache.
+func (o *extOps) Decr(ctx context.Context, key string, delta int64) (int64, error) {
+	start := time.Now()
+	n, err := o.ext.Decr(ctx, key, delta)
+	o.c.recordKeys("decr", []string{key}, err, start)
+	return n, err
+}
+
+// SetNX implements cache.ExtendedCache.
+func (o *extOps) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	start := time.Now()
+	ok, err := o.ext.SetNX(ctx, key, value, expiration)
+	o.c.recordKeys("setnx", []string{key}, err, start)
+	if ok {
+		o.c.observeSize("setnx", key, value)
+	}
+	return ok, err
+}
+
+// Instrumented operations of cache.TaggedCache
+type tagOps struct {
+	c      *instrumentedCache
+	tagged cache.TaggedCache
+}
+
+// SetWithTags implements cache.TaggedCache.
+func (o *tagOps) SetWithTags(ctx context.Context, key string, value interface{}, expiration time.Duration, tags ...string) error {
+	start := time.Now()
+	err := o.tagged.SetWithTags(ctx, key, value, expiration, tags...)
+	o.c.recordKeys("set", []string{key}, err, start)
+	if err == nil {
+		o.c.observeSize("set", key, value)
+	}
+	return err
+}
+
+// InvalidateTags implements cache.TaggedCache, namespaces are taken from tags.
+func (o *tagOps) InvalidateTags(ctx context.Context, tags ...string) error {
+	start := time.Now()
+	err := o.tagged.InvalidateTags(ctx, tags...)
+	o.c.recordKeys("invalidate_tags", tags, err, start)
+	return err
+}
+
+// DelByPrefix implements cache.TaggedCache.
+func (o *tagOps) DelByPrefix(ctx context.Context, prefix string) error {
+	start := time.Now()
+	err := o.tagged.DelByPrefix(ctx, prefix)
+	o.c.recordKeys("del_by_prefix", []string{prefix}, err, start)
+	return err
+}
+
+type extendedCache struct {
+	*instrumentedCache
+	*extOps
+}
+
+type taggedCache struct {
+	*instrumentedCache
+	*tagOps
+}
+
+type fullCache struct {
+	*instrumentedCache
+	*extOps
+	*tagOps
+}
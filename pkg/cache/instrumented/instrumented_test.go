@@ -0,0 +1,157 @@
+package instrumented
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/cache"
+	"github.com/ofavor/ddd-go/pkg/cache/memory"
+	"github.com/ofavor/ddd-go/pkg/metrics"
+)
+
+type recordMetrics struct {
+	counters   map[string]float64
+	histograms map[string]int
+	lock       sync.Mutex
+}
+
+func newRecordMetrics() *recordMetrics {
+	return &recordMetrics{counters: map[string]float64{}, histograms: map[string]int{}}
+}
+
+func series(name string, labels metrics.Labels) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(pairs)
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (m *recordMetrics) IncCounter(name string, labels metrics.Labels, delta float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.counters[series(name, labels)] += delta
+}
+
+func (m *recordMetrics) ObserveHistogram(name string, labels metrics.Labels, value float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.histograms[series(name, labels)]++
+}
+
+func TestNamespace(t *testing.T) {
+	cases := map[string]string{"user:1": "user", "user:1:name": "user", "plain": "default", ":1": "default"}
+	for key, ns := range cases {
+		if n := Namespace(key); n != ns {
+			t.Errorf("namespace of '%s' expected to be '%s' but got '%s'", key, ns, n)
+		}
+	}
+}
+
+func TestInstrumentedCache(t *testing.T) {
+	m := newRecordMetrics()
+	c := NewCache(memory.NewCache(100), m, "main", WithPayloadSampling(1))
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "user:1", "tom", time.Minute); err != nil {
+		t.Fatalf("no error expected for Set but got '%v'", err)
+	}
+	var v string
+	if err := c.Get(ctx, "user:1", &v); err != nil || v != "tom" {
+		t.Errorf("'tom' expected but got '%s', %v", v, err)
+	}
+	if err := c.Get(ctx, "user:2", &v); err != cache.ErrNil {
+		t.Errorf("ErrNil expected but got '%v'", err)
+	}
+	if err := c.Del(ctx, "user:1", "order:1"); err != nil {
+		t.Errorf("no error expected for Del but got '%v'", err)
+	}
+
+	expected := map[string]float64{
+		"cache_requests_total{cache=main,namespace=user,op=set,result=ok}":   1,
+		"cache_requests_total{cache=main,namespace=user,op=get,result=hit}":  1,
+		"cache_requests_total{cache=main,namespace=user,op=get,result=miss}": 1,
+		"cache_requests_total{cache=main,namespace=user,op=del,result=ok}":   1,
+		"cache_requests_total{cache=main,namespace=order,op=del,result=ok}":  1,
+	}
+	for s, n := range expected {
+		if m.counters[s] != n {
+			t.Errorf("%s expected to be %v but got %v", s, n, m.counters[s])
+		}
+	}
+	if n := m.histograms["cache_latency_seconds{cache=main,namespace=user,op=get}"]; n != 2 {
+		t.Errorf("2 latency observations expected for get but got %d", n)
+	}
+	if n := m.histograms["cache_payload_bytes{cache=main,namespace=user,op=get}"]; n != 1 {
+		t.Errorf("1 payload observation expected for get hit but got %d", n)
+	}
+	if n := m.histograms["cache_payload_bytes{cache=main,namespace=user,op=set}"]; n != 1 {
+		t.Errorf("1 payload observation expected for set but got %d", n)
+	}
+}
+
+func TestPayloadSamplingDisabled(t *testing.T) {
+	m := newRecordMetrics()
+	c := NewCache(memory.NewCache(100), m, "main")
+	ctx := context.Background()
+	c.Set(ctx, "user:1", "tom", time.Minute)
+	var v string
+	c.Get(ctx, "user:1", &v)
+	if n := m.histograms["cache_payload_bytes{cache=main,namespace=user,op=get}"]; n != 0 {
+		t.Errorf("payload should not be measured by default but got %d observations", n)
+	}
+}
+
+func TestExtendedAndTaggedKept(t *testing.T) {
+	m := newRecordMetrics()
+	c := NewCache(memory.NewCache(100), m, "main")
+	ext, ok := c.(cache.ExtendedCache)
+	if !ok {
+		t.Fatal("instrumented cache should implement cache.ExtendedCache")
+	}
+	tagged, ok := c.(cache.TaggedCache)
+	if !ok {
+		t.Fatal("instrumented cache should implement cache.TaggedCache")
+	}
+	ctx := context.Background()
+	ext.MSet(ctx, map[string]interface{}{"user:1": "tom"}, time.Minute)
+	out := map[string]string{}
+	if err := ext.MGet(ctx, []string{"user:1", "user:2"}, &out); err != nil || out["user:1"] != "tom" {
+		t.Errorf("'tom' expected but got '%s', %v", out["user:1"], err)
+	}
+	if n, _ := ext.Incr(ctx, "counter:1", 2); n != 2 {
+		t.Errorf("2 expected for Incr but got %d", n)
+	}
+	tagged.SetWithTags(ctx, "query:1", 1, time.Minute, "user:1")
+	if err := tagged.InvalidateTags(ctx, "user:1"); err != nil {
+		t.Errorf("no error expected for InvalidateTags but got '%v'", err)
+	}
+
+	expected := map[string]float64{
+		"cache_requests_total{cache=main,namespace=user,op=mset,result=ok}":            1,
+		"cache_requests_total{cache=main,namespace=user,op=mget,result=hit}":           1,
+		"cache_requests_total{cache=main,namespace=user,op=mget,result=miss}":          1,
+		"cache_requests_total{cache=main,namespace=counter,op=incr,result=ok}":         1,
+		"cache_requests_total{cache=main,namespace=query,op=set,result=ok}":            1,
+		"cache_requests_total{cache=main,namespace=user,op=invalidate_tags,result=ok}": 1,
+	}
+	for s, n := range expected {
+		if m.counters[s] != n {
+			t.Errorf("%s expected to be %v but got %v", s, n, m.counters[s])
+		}
+	}
+
+	if _, ok := NewCache(&plainCache{}, m, "plain").(cache.ExtendedCache); ok {
+		t.Error("instrumented plain cache should not implement cache.ExtendedCache")
+	}
+}
+
+type plainCache struct {
+	cache.Cache
+}
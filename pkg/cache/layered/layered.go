@@ -0,0 +1,147 @@
+package layered
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/cache"
+	"github.com/ofavor/ddd-go/pkg/log"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Layered cache interface
+type Cache interface {
+	cache.Cache
+
+	// Stop receiving invalidations
+	Close() error
+}
+
+type invalidation struct {
+	Source string   `json:"source"`
+	Keys   []string `json:"keys"`
+}
+
+// layeredCache two-level cache, the local cache is checked before the remote one.
+// Writes are broadcasted through redis pub/sub, so that other instances drop their local copies.
+type layeredCache struct {
+	id       string
+	local    cache.Cache
+	remote   cache.Cache
+	conn     redis.UniversalClient
+	channel  string
+	localTTL time.Duration
+	pubsub   *redis.PubSub
+	done     chan struct{}
+}
+
+// NewCache create layered cache, local entries live for localTTL at most.
+// Local copies might be stale for localTTL at most if an invalidation is missed.
+func NewCache(local cache.Cache, remote cache.Cache, conn redis.UniversalClient, channel string, localTTL time.Duration) (Cache, error) {
+	c := &layeredCache{
+		id:       uuid.NewString(),
+		local:    local,
+		remote:   remote,
+		conn:     conn,
+		channel:  channel,
+		localTTL: localTTL,
+		done:     make(chan struct{}),
+	}
+	c.pubsub = conn.Subscribe(context.Background(), channel)
+	if _, err := c.pubsub.Receive(context.Background()); err != nil { // wait for subscription confirmation
+		c.pubsub.Close()
+		return nil, err
+	}
+	go c.receive()
+	return c, nil
+}
+
+func (c *layeredCache) receive() {
+	defer close(c.done)
+	for msg := range c.pubsub.Channel() {
+		inv := invalidation{}
+		if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+			log.Warnf("[cache-layered] Got error while decoding invalidation: %v", err)
+			continue
+		}
+		if inv.Source == c.id {
+			continue
+		}
+		log.Debugf("[cache-layered] invalidate %v", inv.Keys)
+		if err := c.local.Del(context.Background(), inv.Keys...); err != nil {
+			log.Warnf("[cache-layered] Got error while invalidating local cache: %v", err)
+		}
+	}
+}
+
+func (c *layeredCache) broadcast(ctx context.Context, keys []string) {
+	j, _ := json.Marshal(invalidation{Source: c.id, Keys: keys})
+	if err := c.conn.Publish(ctx, c.channel, string(j)).Err(); err != nil {
+		log.Warnf("[cache-layered] Got error while broadcasting invalidation: %v", err)
+	}
+}
+
+func (c *layeredCache) ttl(expiration time.Duration) time.Duration {
+	if expiration > 0 && expiration < c.localTTL {
+		return expiration
+	}
+	return c.localTTL
+}
+
+// Get connection returns the connection of remote cache
+func (c *layeredCache) GetConn() interface{} {
+	return c.remote.GetConn()
+}
+
+// Codec implements cache.CodecProvider, values are encoded by remote cache.
+func (c *layeredCache) Codec() cache.Codec {
+	if p, ok := c.remote.(cache.CodecProvider); ok {
+		return p.Codec()
+	}
+	return cache.JSONCodec
+}
+
+// Set value to cache
+func (c *layeredCache) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	if err := c.remote.Set(ctx, key, value, expiration); err != nil {
+		c.local.Del(ctx, key)
+		return err
+	}
+	if err := c.local.Set(ctx, key, value, c.ttl(expiration)); err != nil {
+		log.Warnf("[cache-layered] set local (%s) failed: %v", key, err)
+	}
+	c.broadcast(ctx, []string{key})
+	return nil
+}
+
+// Get value from cache
+func (c *layeredCache) Get(ctx context.Context, key string, out interface{}) error {
+	if err := c.local.Get(ctx, key, out); err == nil {
+		return nil
+	}
+	if err := c.remote.Get(ctx, key, out); err != nil {
+		return err
+	}
+	if err := c.local.Set(ctx, key, out, c.localTTL); err != nil {
+		log.Warnf("[cache-layered] set local (%s) failed: %v", key, err)
+	}
+	return nil
+}
+
+// Delete value from cache
+func (c *layeredCache) Del(ctx context.Context, key ...string) error {
+	c.local.Del(ctx, key...)
+	err := c.remote.Del(ctx, key...)
+	c.broadcast(ctx, key)
+	return err
+}
+
+// Close implements Cache.
+func (c *layeredCache) Close() error {
+	err := c.pubsub.Close()
+	<-c.done
+	return err
+}
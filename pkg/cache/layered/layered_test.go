@@ -0,0 +1,100 @@
+package layered
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/cache"
+	"github.com/ofavor/ddd-go/pkg/cache/memory"
+	cacheredis "github.com/ofavor/ddd-go/pkg/cache/redis"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestCache(t *testing.T, s *miniredis.Miniredis) *layeredCache {
+	conn := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	c, err := NewCache(memory.NewCache(100), cacheredis.NewCacheWithConn(conn, "test"), conn, "test.invalidation", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+		conn.Close()
+	})
+	return c.(*layeredCache)
+}
+
+func waitForValue(c cache.Cache, key string, expected string) bool {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		var out string
+		if err := c.Get(context.Background(), key, &out); err == nil && out == expected {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func TestLocalHit(t *testing.T) {
+	s := miniredis.RunT(t)
+	c := newTestCache(t, s)
+	ctx := context.Background()
+	key := "key.test"
+	c.Set(ctx, key, "hello", 0)
+	s.FlushAll() // value is still in local cache
+	var out string
+	if err := c.Get(ctx, key, &out); err != nil || out != "hello" {
+		t.Errorf("expected local value 'hello' but got '%s', '%v'", out, err)
+	}
+}
+
+func TestRemoteFallback(t *testing.T) {
+	s := miniredis.RunT(t)
+	c1 := newTestCache(t, s)
+	c2 := newTestCache(t, s)
+	ctx := context.Background()
+	key := "key.test"
+	c1.Set(ctx, key, "hello", 0)
+	var out string
+	if err := c2.Get(ctx, key, &out); err != nil || out != "hello" {
+		t.Errorf("expected remote value 'hello' but got '%s', '%v'", out, err)
+	}
+	if err := c2.local.Get(ctx, key, &out); err != nil {
+		t.Error("remote value should be stored in local cache")
+	}
+	if err := c2.Get(ctx, "key.none", &out); err != cache.ErrNil {
+		t.Errorf("expected error 'cache is nil' but got '%v'", err)
+	}
+}
+
+func TestCrossInstanceInvalidation(t *testing.T) {
+	s := miniredis.RunT(t)
+	c1 := newTestCache(t, s)
+	c2 := newTestCache(t, s)
+	ctx := context.Background()
+	key := "key.test"
+	c1.Set(ctx, key, "v1", 0)
+	if !waitForValue(c2, key, "v1") {
+		t.Fatal("expected 'v1' from c2")
+	}
+	c1.Set(ctx, key, "v2", 0)
+	if !waitForValue(c2, key, "v2") {
+		t.Error("local copy of c2 should be invalidated after Set on c1")
+	}
+	c1.Del(ctx, key)
+	deadline := time.Now().Add(time.Second)
+	var err error
+	for time.Now().Before(deadline) {
+		var out string
+		if err = c2.Get(ctx, key, &out); err == cache.ErrNil {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err != cache.ErrNil {
+		t.Errorf("local copy of c2 should be invalidated after Del on c1 but got '%v'", err)
+	}
+}
@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/log"
+
+	"golang.org/x/sync/singleflight"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Loader loads the value when cache misses, return ErrNotFound if the value does not exist
+type Loader func(ctx context.Context) (interface{}, error)
+
+// Locker coordinates loads across instances, mutex.Mutex satisfies it
+type Locker interface {
+	Lock(key string, expiration time.Duration) error
+	Unlock(key string) error
+}
+
+type loadOptions struct {
+	locker      Locker
+	lockTTL     time.Duration
+	negativeTTL time.Duration
+	timeout     time.Duration
+}
+
+// GetOrLoad option
+type LoadOption func(o *loadOptions)
+
+// Coordinate loads across instances with a locker, the instances failed to get the lock
+// wait for the value for lockTTL at most and then load it by themselves
+func WithLocker(l Locker, lockTTL time.Duration) LoadOption {
+	return func(o *loadOptions) {
+		o.locker = l
+		o.lockTTL = lockTTL
+	}
+}
+
+// Cache "not found" results for ttl
+func WithNegativeTTL(ttl time.Duration) LoadOption {
+	return func(o *loadOptions) {
+		o.negativeTTL = ttl
+	}
+}
+
+// Set timeout of a load, 10s by default
+func WithLoadTimeout(d time.Duration) LoadOption {
+	return func(o *loadOptions) {
+		o.timeout = d
+	}
+}
+
+// Cache whose values are serialized by a codec, GetOrLoad copies loaded values with it. JSON is assumed
+// for caches not implementing it.
+type CodecProvider interface {
+	Codec() Codec
+}
+
+var loadGroup = new(singleflight.Group)
+
+const defaultLoadTimeout = 10 * time.Second
+
+// Interval to check the value while another instance is loading it
+const loadWaitInterval = 50 * time.Millisecond
+
+// Get value from cache, load and set it if cache misses.
+// Concurrent loads of the same key are deduplicated in process, every caller gets its own copy of the value
+// decoded by the codec of cache. The load is not canceled with the caller started it since others share it,
+// it is bounded by the load timeout instead, and each caller stops waiting when its own ctx is done.
+// ErrNotFound is returned if the loader reports it.
+func GetOrLoad(ctx context.Context, c Cache, key string, out interface{}, ttl time.Duration, loader Loader, opts ...LoadOption) error {
+	err := c.Get(ctx, key, out)
+	if err != ErrNil {
+		return err
+	}
+	o := &loadOptions{timeout: defaultLoadTimeout}
+	for _, opt := range opts {
+		opt(o)
+	}
+	codec := JSONCodec
+	if p, ok := c.(CodecProvider); ok {
+		codec = p.Codec()
+	}
+	ch := loadGroup.DoChan(fmt.Sprintf("%p:%s", c, key), func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), o.timeout)
+		defer cancel()
+		// decode into a fresh value, so that callers never share the value being decoded
+		fresh := reflect.New(reflect.TypeOf(out).Elem()).Interface()
+		v, err := load(ctx, c, key, fresh, ttl, loader, o)
+		if err != nil {
+			return nil, err
+		}
+		return codec.Marshal(v)
+	})
+	select {
+	case r := <-ch:
+		if r.Err != nil {
+			return r.Err
+		}
+		return codec.Unmarshal(r.Val.([]byte), out)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func negativeKey(key string) string {
+	return fmt.Sprintf("__nil__:%s", key)
+}
+
+func load(ctx context.Context, c Cache, key string, out interface{}, ttl time.Duration, loader Loader, o *loadOptions) (interface{}, error) {
+	if o.negativeTTL > 0 {
+		var b bool
+		if err := c.Get(ctx, negativeKey(key), &b); err == nil {
+			return nil, ErrNotFound
+		}
+	}
+	if o.locker != nil {
+		lkey := fmt.Sprintf("__load__:%s", key)
+		deadline := time.Now().Add(o.lockTTL)
+		for o.locker.Lock(lkey, o.lockTTL) != nil {
+			if time.Now().After(deadline) {
+				log.Warnf("[cache] Wait for loading (%s) timeout, load it by self", key)
+				return loadAndSet(ctx, c, key, ttl, loader, o)
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(loadWaitInterval):
+			}
+			if err := c.Get(ctx, key, out); err != ErrNil {
+				return out, err
+			}
+		}
+		defer o.locker.Unlock(lkey)
+		// check again, the value might be loaded by others while waiting for the lock
+		if err := c.Get(ctx, key, out); err != ErrNil {
+			return out, err
+		}
+	}
+	return loadAndSet(ctx, c, key, ttl, loader, o)
+}
+
+func loadAndSet(ctx context.Context, c Cache, key string, ttl time.Duration, loader Loader, o *loadOptions) (interface{}, error) {
+	v, err := loader(ctx)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) && o.negativeTTL > 0 {
+			if err := c.Set(ctx, negativeKey(key), true, o.negativeTTL); err != nil {
+				log.Warnf("[cache] Got error while caching not found result of (%s): %v", key, err)
+			}
+		}
+		return nil, err
+	}
+	if err := c.Set(ctx, key, v, ttl); err != nil {
+		log.Warnf("[cache] Got error while caching loaded value of (%s): %v", key, err)
+	}
+	return v, nil
+}
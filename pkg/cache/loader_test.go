@@ -0,0 +1,194 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/cache"
+	"github.com/ofavor/ddd-go/pkg/cache/memory"
+	"github.com/ofavor/ddd-go/pkg/mutex/local"
+)
+
+type user struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func TestGetOrLoad(t *testing.T) {
+	c := memory.NewCache(0)
+	ctx := context.Background()
+	count := 0
+	loader := func(ctx context.Context) (interface{}, error) {
+		count++
+		return &user{Name: "test", Age: 20}, nil
+	}
+	for i := 0; i < 2; i++ {
+		out := user{}
+		if err := cache.GetOrLoad(ctx, c, "user.1", &out, time.Minute, loader); err != nil {
+			t.Errorf("no error expected for GetOrLoad but got '%v'", err)
+		}
+		if out.Name != "test" || out.Age != 20 {
+			t.Errorf("expected 'test,20' but got '%s,%d'", out.Name, out.Age)
+		}
+	}
+	if count != 1 {
+		t.Errorf("expected 1 load but got %d", count)
+	}
+}
+
+func TestGetOrLoadSingleflight(t *testing.T) {
+	c := memory.NewCache(0)
+	ctx := context.Background()
+	var count atomic.Int32
+	loader := func(ctx context.Context) (interface{}, error) {
+		count.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		return user{Name: "test", Age: 20}, nil
+	}
+	wg := &sync.WaitGroup{}
+	outs := make([]*user, 10)
+	for i := range outs {
+		outs[i] = &user{}
+		wg.Add(1)
+		go func(out *user) {
+			defer wg.Done()
+			if err := cache.GetOrLoad(ctx, c, "user.1", out, time.Minute, loader); err != nil {
+				t.Errorf("no error expected for GetOrLoad but got '%v'", err)
+			}
+		}(outs[i])
+	}
+	wg.Wait()
+	if count.Load() != 1 {
+		t.Errorf("expected 1 load but got %d", count.Load())
+	}
+	for _, out := range outs {
+		if out.Name != "test" {
+			t.Errorf("expected 'test' but got '%s'", out.Name)
+		}
+	}
+	outs[0].Name = "changed"
+	if outs[1].Name != "test" {
+		t.Error("callers should get their own copies")
+	}
+}
+
+func TestGetOrLoadWithLocker(t *testing.T) {
+	c := memory.NewCache(0)
+	m := local.NewMutex()
+	ctx := context.Background()
+	// another instance is loading the value
+	m.Lock("__load__:user.1", time.Second)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		c.Set(ctx, "user.1", user{Name: "other", Age: 30}, time.Minute)
+	}()
+	count := 0
+	loader := func(ctx context.Context) (interface{}, error) {
+		count++
+		return user{Name: "test", Age: 20}, nil
+	}
+	out := user{}
+	if err := cache.GetOrLoad(ctx, c, "user.1", &out, time.Minute, loader, cache.WithLocker(m, time.Second)); err != nil {
+		t.Errorf("no error expected for GetOrLoad but got '%v'", err)
+	}
+	if count != 0 || out.Name != "other" {
+		t.Errorf("value loaded by other instance expected but got '%s' with %d loads", out.Name, count)
+	}
+}
+
+func TestGetOrLoadNegativeCache(t *testing.T) {
+	c := memory.NewCache(0)
+	ctx := context.Background()
+	count := 0
+	loader := func(ctx context.Context) (interface{}, error) {
+		count++
+		return nil, cache.ErrNotFound
+	}
+	for i := 0; i < 2; i++ {
+		out := user{}
+		err := cache.GetOrLoad(ctx, c, "user.1", &out, time.Minute, loader, cache.WithNegativeTTL(time.Minute))
+		if !errors.Is(err, cache.ErrNotFound) {
+			t.Errorf("expected error 'not found' but got '%v'", err)
+		}
+	}
+	if count != 1 {
+		t.Errorf("not found result should be cached but got %d loads", count)
+	}
+	out := user{}
+	cache.GetOrLoad(ctx, c, "user.2", &out, time.Minute, loader)
+	cache.GetOrLoad(ctx, c, "user.2", &out, time.Minute, loader)
+	if count != 3 {
+		t.Errorf("not found result should not be cached without negative ttl but got %d loads", count)
+	}
+}
+
+func TestGetOrLoadCallerCanceled(t *testing.T) {
+	c := memory.NewCache(0)
+	started := make(chan struct{})
+	loader := func(ctx context.Context) (interface{}, error) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return &user{Name: "test", Age: 20}, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		out := user{}
+		first <- cache.GetOrLoad(ctx, c, "user.1", &out, time.Minute, loader)
+	}()
+	<-started
+	second := make(chan error, 1)
+	out := user{}
+	go func() {
+		second <- cache.GetOrLoad(context.Background(), c, "user.1", &out, time.Minute, loader)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("canceled caller expected to get context.Canceled but got '%v'", err)
+	}
+	if err := <-second; err != nil || out.Name != "test" {
+		t.Errorf("other callers should not be failed by canceled caller but got '%v'", err)
+	}
+}
+
+type countingCodec struct {
+	cache.Codec
+	unmarshals atomic.Int32
+}
+
+func (c *countingCodec) Unmarshal(data []byte, v interface{}) error {
+	c.unmarshals.Add(1)
+	return c.Codec.Unmarshal(data, v)
+}
+
+type codecCache struct {
+	cache.Cache
+	codec cache.Codec
+}
+
+func (c *codecCache) Codec() cache.Codec {
+	return c.codec
+}
+
+func TestGetOrLoadCodec(t *testing.T) {
+	codec := &countingCodec{Codec: cache.GobCodec}
+	c := &codecCache{Cache: memory.NewCache(0), codec: codec}
+	loader := func(ctx context.Context) (interface{}, error) {
+		return &user{Name: "test", Age: 20}, nil
+	}
+	out := user{}
+	if err := cache.GetOrLoad(context.Background(), c, "user.1", &out, time.Minute, loader); err != nil || out.Name != "test" {
+		t.Errorf("expected 'test' but got '%s', %v", out.Name, err)
+	}
+	if codec.unmarshals.Load() != 1 {
+		t.Errorf("loaded value expected to be decoded by codec of cache but got %d decodes", codec.unmarshals.Load())
+	}
+}
@@ -0,0 +1,403 @@
+package memory
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/cache"
+	"github.com/ofavor/ddd-go/pkg/log"
+)
+
+// Memory cache interface
+type Cache interface {
+	cache.ExtendedCache
+	cache.TaggedCache
+
+	// Stop the janitor
+	Close() error
+}
+
+type entry struct {
+	key      string
+	value    []byte
+	expireAt time.Time // zero means never expire
+	tags     []string
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !e.expireAt.After(now)
+}
+
+// Minimum number of entries to trigger the amortized eviction of expired entries
+const minSweepSize = 64
+
+// Memory cache option
+type Option func(c *memoryCache)
+
+// Start a janitor to evict expired entries periodically in background, stop it by Close
+func WithJanitor(interval time.Duration) Option {
+	return func(c *memoryCache) {
+		c.janitorInterval = interval
+	}
+}
+
+// memoryCache in-process cache implementation with LRU eviction.
+// Values are stored as JSON like redis does, so callers can't mutate cached values.
+type memoryCache struct {
+	size            int
+	entries         map[string]*list.Element
+	lru             *list.List
+	tags            map[string]map[string]struct{}
+	lock            *sync.Mutex
+	sweepSize       int
+	janitorInterval time.Duration
+	stop            chan struct{}
+	done            chan struct{}
+}
+
+// NewCache create memory cache, the least recently used entries are evicted when there are more than size entries.
+// Size less than or equal to zero means unbounded. Expired entries are evicted when they are read, or by a sweep
+// whenever the number of entries doubles, see WithJanitor for periodical eviction.
+func NewCache(size int, opts ...Option) Cache {
+	return newMemoryCache(size, opts...)
+}
+
+func newMemoryCache(size int, opts ...Option) *memoryCache {
+	c := &memoryCache{
+		size:      size,
+		entries:   make(map[string]*list.Element),
+		lru:       list.New(),
+		tags:      make(map[string]map[string]struct{}),
+		lock:      new(sync.Mutex),
+		sweepSize: minSweepSize,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.janitorInterval > 0 {
+		c.stop = make(chan struct{})
+		c.done = make(chan struct{})
+		go c.janitor(c.stop, c.done)
+	}
+	return c
+}
+
+func (c *memoryCache) janitor(stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(c.janitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.lock.Lock()
+			c.evict(time.Now())
+			c.lock.Unlock()
+		}
+	}
+}
+
+// Evict expired entries. Must be called with lock held.
+func (c *memoryCache) evict(now time.Time) {
+	for _, el := range c.entries {
+		if el.Value.(*entry).expired(now) {
+			c.remove(el)
+		}
+	}
+	// entries are swept again only when they double, so the cost is amortized across sets
+	c.sweepSize = 2 * len(c.entries)
+	if c.sweepSize < minSweepSize {
+		c.sweepSize = minSweepSize
+	}
+}
+
+// Close stops the janitor
+func (c *memoryCache) Close() error {
+	if c.stop != nil {
+		close(c.stop)
+		<-c.done
+		c.stop = nil
+	}
+	return nil
+}
+
+func expireAt(now time.Time, expiration time.Duration) time.Time {
+	if expiration <= 0 {
+		return time.Time{}
+	}
+	return now.Add(expiration)
+}
+
+// Get entry of key, expired entry is removed. Must be called with lock held.
+func (c *memoryCache) get(key string, now time.Time) *entry {
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*entry)
+	if e.expired(now) {
+		c.remove(el)
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	return e
+}
+
+// Set entry of key and evict the least recently used ones. Must be called with lock held.
+func (c *memoryCache) set(key string, value []byte, expireAt time.Time) {
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*entry)
+		e.value = value
+		e.expireAt = expireAt
+		c.lru.MoveToFront(el)
+		return
+	}
+	if len(c.entries) >= c.sweepSize {
+		c.evict(time.Now())
+	}
+	c.entries[key] = c.lru.PushFront(&entry{key: key, value: value, expireAt: expireAt})
+	for c.size > 0 && c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Remove entry and its keys in tags. Must be called with lock held.
+func (c *memoryCache) remove(el *list.Element) {
+	e := el.Value.(*entry)
+	c.lru.Remove(el)
+	delete(c.entries, e.key)
+	for _, tag := range e.tags {
+		if keys, ok := c.tags[tag]; ok {
+			delete(keys, e.key)
+			if len(keys) == 0 {
+				delete(c.tags, tag)
+			}
+		}
+	}
+}
+
+// Get connection returns nil, there is no underlying connection
+func (c *memoryCache) GetConn() interface{} {
+	return nil
+}
+
+// Codec implements cache.CodecProvider, values are stored as JSON.
+func (c *memoryCache) Codec() cache.Codec {
+	return cache.JSONCodec
+}
+
+// Set value to cache
+func (c *memoryCache) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	j, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	log.Debugf("[cache-mem] set %s = %s", key, j)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	c.set(key, j, expireAt(now, expiration))
+	return nil
+}
+
+// Get value from cache
+func (c *memoryCache) Get(ctx context.Context, key string, out interface{}) error {
+	c.lock.Lock()
+	e := c.get(key, time.Now())
+	var val []byte
+	if e != nil {
+		val = e.value
+	}
+	c.lock.Unlock()
+	log.Debugf("[cache-mem] get %s = %s", key, val)
+	if e == nil {
+		return cache.ErrNil
+	}
+	return json.Unmarshal(val, out)
+}
+
+// Delete value from cache
+func (c *memoryCache) Del(ctx context.Context, key ...string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, k := range key {
+		if el, ok := c.entries[k]; ok {
+			c.remove(el)
+		}
+	}
+	return nil
+}
+
+// MGet implements cache.ExtendedCache.
+func (c *memoryCache) MGet(ctx context.Context, keys []string, out interface{}) error {
+	c.lock.Lock()
+	now := time.Now()
+	values := make(map[string][]byte, len(keys))
+	for _, k := range keys {
+		if e := c.get(k, now); e != nil {
+			values[k] = e.value
+		}
+	}
+	c.lock.Unlock()
+	return cache.FillMap(out, values, json.Unmarshal)
+}
+
+// MSet implements cache.ExtendedCache.
+func (c *memoryCache) MSet(ctx context.Context, values map[string]interface{}, expiration time.Duration) error {
+	encoded := make(map[string][]byte, len(values))
+	for k, v := range values {
+		j, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		encoded[k] = j
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	exp := expireAt(time.Now(), expiration)
+	for k, j := range encoded {
+		c.set(k, j, exp)
+	}
+	return nil
+}
+
+// Exists implements cache.ExtendedCache.
+func (c *memoryCache) Exists(ctx context.Context, keys ...string) (int64, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	var n int64
+	for _, k := range keys {
+		if c.get(k, now) != nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// TTL implements cache.ExtendedCache.
+func (c *memoryCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	e := c.get(key, now)
+	if e == nil {
+		return 0, cache.ErrNil
+	}
+	if e.expireAt.IsZero() {
+		return 0, nil
+	}
+	return e.expireAt.Sub(now), nil
+}
+
+// Expire implements cache.ExtendedCache.
+func (c *memoryCache) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	e := c.get(key, now)
+	if e == nil {
+		return cache.ErrNil
+	}
+	e.expireAt = expireAt(now, expiration)
+	return nil
+}
+
+// Incr implements cache.ExtendedCache.
+func (c *memoryCache) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	var n int64
+	var exp time.Time
+	if e := c.get(key, now); e != nil {
+		v, err := strconv.ParseInt(string(e.value), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("[cache-mem] Value of (%s) is not an integer", key)
+		}
+		n = v
+		exp = e.expireAt
+	}
+	n += delta
+	c.set(key, []byte(strconv.FormatInt(n, 10)), exp)
+	return n, nil
+}
+
+// Decr implements cache.ExtendedCache.
+func (c *memoryCache) Decr(ctx context.Context, key string, delta int64) (int64, error) {
+	return c.Incr(ctx, key, -delta)
+}
+
+// SetNX implements cache.ExtendedCache.
+func (c *memoryCache) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	j, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	if c.get(key, now) != nil {
+		return false, nil
+	}
+	c.set(key, j, expireAt(now, expiration))
+	return true, nil
+}
+
+// SetWithTags implements cache.TaggedCache.
+func (c *memoryCache) SetWithTags(ctx context.Context, key string, value interface{}, expiration time.Duration, tags ...string) error {
+	j, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.set(key, j, expireAt(time.Now(), expiration))
+	e := c.entries[key].Value.(*entry)
+	for _, tag := range tags {
+		keys, ok := c.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.tags[tag] = keys
+		}
+		if _, ok := keys[key]; !ok {
+			keys[key] = struct{}{}
+			e.tags = append(e.tags, tag)
+		}
+	}
+	return nil
+}
+
+// InvalidateTags implements cache.TaggedCache.
+func (c *memoryCache) InvalidateTags(ctx context.Context, tags ...string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, tag := range tags {
+		for k := range c.tags[tag] {
+			if el, ok := c.entries[k]; ok {
+				c.remove(el)
+			}
+		}
+		delete(c.tags, tag)
+	}
+	return nil
+}
+
+// DelByPrefix implements cache.TaggedCache.
+func (c *memoryCache) DelByPrefix(ctx context.Context, prefix string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for k, el := range c.entries {
+		if strings.HasPrefix(k, prefix) {
+			c.remove(el)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/cache"
+)
+
+func TestSetGet(t *testing.T) {
+	c := newMemoryCache(0)
+	ctx := context.Background()
+	key := "key.test"
+	structval := struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}{
+		Name: "test",
+		Age:  20,
+	}
+	if err := c.Set(ctx, key, structval, 0); err != nil {
+		t.Error("no error expected for Set struct value")
+	}
+	structval.Name = ""
+	if err := c.Get(ctx, key, &structval); err != nil {
+		t.Error("no error expected for Get struct value")
+	}
+	if structval.Name != "test" || structval.Age != 20 {
+		t.Errorf("expected 'test,20' but got '%s,%d'", structval.Name, structval.Age)
+	}
+}
+
+func TestCopySemantics(t *testing.T) {
+	c := newMemoryCache(0)
+	ctx := context.Background()
+	key := "key.test"
+	arrval := []string{"a", "b", "c"}
+	c.Set(ctx, key, arrval, 0)
+	arrval[0] = "x"
+	out := []string{}
+	c.Get(ctx, key, &out)
+	if out[0] != "a" {
+		t.Errorf("cached value should not be changed by caller but got '%s'", out[0])
+	}
+	out[1] = "y"
+	out2 := []string{}
+	c.Get(ctx, key, &out2)
+	if out2[1] != "b" {
+		t.Errorf("cached value should not be changed by caller but got '%s'", out2[1])
+	}
+}
+
+func TestGetNil(t *testing.T) {
+	c := newMemoryCache(0)
+	var out string
+	if err := c.Get(context.Background(), "key.test", &out); err != cache.ErrNil {
+		t.Errorf("expected error 'cache is nil' but got '%v'", err)
+	}
+}
+
+func TestExpiration(t *testing.T) {
+	c := newMemoryCache(0)
+	ctx := context.Background()
+	key := "key.test"
+	c.Set(ctx, key, "hello", 10*time.Millisecond)
+	var out string
+	if err := c.Get(ctx, key, &out); err != nil {
+		t.Error("no error expected for Get before expiration")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := c.Get(ctx, key, &out); err != cache.ErrNil {
+		t.Errorf("expected error 'cache is nil' after expiration but got '%v'", err)
+	}
+	if len(c.entries) != 0 {
+		t.Error("expired entry should be removed")
+	}
+}
+
+func TestLRUEviction(t *testing.T) {
+	c := newMemoryCache(2)
+	ctx := context.Background()
+	var out string
+	c.Set(ctx, "key.1", "1", 0)
+	c.Set(ctx, "key.2", "2", 0)
+	c.Get(ctx, "key.1", &out) // key.2 becomes the least recently used
+	c.Set(ctx, "key.3", "3", 0)
+	if err := c.Get(ctx, "key.2", &out); err != cache.ErrNil {
+		t.Error("least recently used entry should be evicted")
+	}
+	if err := c.Get(ctx, "key.1", &out); err != nil {
+		t.Error("recently used entry should be kept")
+	}
+	if err := c.Get(ctx, "key.3", &out); err != nil {
+		t.Error("new entry should be kept")
+	}
+}
+
+func TestDel(t *testing.T) {
+	c := newMemoryCache(0)
+	ctx := context.Background()
+	c.Set(ctx, "key.1", "1", 0)
+	c.Set(ctx, "key.2", "2", 0)
+	if err := c.Del(ctx, "key.1", "key.2", "key.3"); err != nil {
+		t.Error("no error expected for Del multiple keys")
+	}
+	if len(c.entries) != 0 || c.lru.Len() != 0 {
+		t.Error("cache should be empty")
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	c := newMemoryCache(10)
+	ctx := context.Background()
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := fmt.Sprintf("key.%d", (i+j)%20)
+				var out int
+				c.Set(ctx, key, j, time.Second)
+				c.Get(ctx, key, &out)
+				c.Del(ctx, key)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if c.lru.Len() > 10 {
+		t.Errorf("cache should be bounded but got %d entries", c.lru.Len())
+	}
+}
+
+func TestMGetMSet(t *testing.T) {
+	c := newMemoryCache(0)
+	ctx := context.Background()
+	if err := c.MSet(ctx, map[string]interface{}{"key.1": 1, "key.2": 2}, time.Minute); err != nil {
+		t.Error("no error expected for MSet")
+	}
+	out := map[string]int{}
+	if err := c.MGet(ctx, []string{"key.1", "key.2", "key.3"}, &out); err != nil {
+		t.Errorf("no error expected for MGet but got '%v'", err)
+	}
+	if len(out) != 2 || out["key.1"] != 1 || out["key.2"] != 2 {
+		t.Errorf("expected 'key.1=1,key.2=2' but got '%v'", out)
+	}
+	if err := c.MGet(ctx, []string{"key.1"}, out); err == nil {
+		t.Error("error expected for MGet with non-pointer output")
+	}
+}
+
+func TestExistsTTLExpire(t *testing.T) {
+	c := newMemoryCache(0)
+	ctx := context.Background()
+	c.Set(ctx, "key.1", 1, 0)
+	c.Set(ctx, "key.2", 2, time.Minute)
+	if n, _ := c.Exists(ctx, "key.1", "key.2", "key.3"); n != 2 {
+		t.Errorf("expected 2 existing keys but got %d", n)
+	}
+	if ttl, err := c.TTL(ctx, "key.1"); err != nil || ttl != 0 {
+		t.Errorf("expected no expiration but got %s, '%v'", ttl, err)
+	}
+	if ttl, err := c.TTL(ctx, "key.2"); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected ttl within 1m but got %s, '%v'", ttl, err)
+	}
+	if _, err := c.TTL(ctx, "key.3"); err != cache.ErrNil {
+		t.Errorf("expected error 'cache is nil' but got '%v'", err)
+	}
+	if err := c.Expire(ctx, "key.1", time.Millisecond); err != nil {
+		t.Error("no error expected for Expire")
+	}
+	if err := c.Expire(ctx, "key.3", time.Minute); err != cache.ErrNil {
+		t.Errorf("expected error 'cache is nil' but got '%v'", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if n, _ := c.Exists(ctx, "key.1"); n != 0 {
+		t.Error("key should be expired")
+	}
+}
+
+func TestIncrDecr(t *testing.T) {
+	c := newMemoryCache(0)
+	ctx := context.Background()
+	if n, err := c.Incr(ctx, "counter", 5); err != nil || n != 5 {
+		t.Errorf("expected 5 but got %d, '%v'", n, err)
+	}
+	if n, err := c.Decr(ctx, "counter", 2); err != nil || n != 3 {
+		t.Errorf("expected 3 but got %d, '%v'", n, err)
+	}
+	var out int
+	if err := c.Get(ctx, "counter", &out); err != nil || out != 3 {
+		t.Errorf("expected 3 but got %d, '%v'", out, err)
+	}
+	c.Set(ctx, "str", "hello", 0)
+	if _, err := c.Incr(ctx, "str", 1); err == nil {
+		t.Error("error expected for Incr on non-integer value")
+	}
+}
+
+func TestSetNX(t *testing.T) {
+	c := newMemoryCache(0)
+	ctx := context.Background()
+	if ok, err := c.SetNX(ctx, "key.1", "v1", 0); err != nil || !ok {
+		t.Error("value should be set for new key")
+	}
+	if ok, err := c.SetNX(ctx, "key.1", "v2", 0); err != nil || ok {
+		t.Error("value should not be set for existing key")
+	}
+	var out string
+	c.Get(ctx, "key.1", &out)
+	if out != "v1" {
+		t.Errorf("expected 'v1' but got '%s'", out)
+	}
+}
+
+func TestTags(t *testing.T) {
+	c := newMemoryCache(0)
+	ctx := context.Background()
+	c.SetWithTags(ctx, "query.1", 1, time.Minute, "user:1", "user:2")
+	c.SetWithTags(ctx, "query.2", 2, time.Minute, "user:2")
+	c.SetWithTags(ctx, "query.3", 3, time.Minute, "user:3")
+	if err := c.InvalidateTags(ctx, "user:2"); err != nil {
+		t.Error("no error expected for InvalidateTags")
+	}
+	if n, _ := c.Exists(ctx, "query.1", "query.2", "query.3"); n != 1 {
+		t.Errorf("expected 1 existing key but got %d", n)
+	}
+}
+
+func TestDelByPrefix(t *testing.T) {
+	c := newMemoryCache(0)
+	ctx := context.Background()
+	c.Set(ctx, "user:1", 1, 0)
+	c.Set(ctx, "user:2", 2, 0)
+	c.Set(ctx, "order:1", 1, 0)
+	if err := c.DelByPrefix(ctx, "user:"); err != nil {
+		t.Error("no error expected for DelByPrefix")
+	}
+	if n, _ := c.Exists(ctx, "user:1", "user:2", "order:1"); n != 1 {
+		t.Errorf("expected 1 existing key but got %d", n)
+	}
+}
+
+func TestAmortizedEviction(t *testing.T) {
+	c := newMemoryCache(0)
+	ctx := context.Background()
+	for i := 0; i < minSweepSize; i++ {
+		c.Set(ctx, fmt.Sprintf("key.%d", i), i, 10*time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	c.Set(ctx, "key.new", 1, 0)
+	if len(c.entries) != 1 || c.lru.Len() != 1 {
+		t.Errorf("expired entries should be evicted but got %d entries", len(c.entries))
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	c := newMemoryCache(0, WithJanitor(10*time.Millisecond))
+	defer c.Close()
+	c.Set(context.Background(), "key.test", 1, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	c.lock.Lock()
+	n := len(c.entries)
+	c.lock.Unlock()
+	if n != 0 {
+		t.Errorf("expired entry should be evicted by janitor but got %d entries", n)
+	}
+}
+
+func TestTagsPruned(t *testing.T) {
+	c := newMemoryCache(1)
+	ctx := context.Background()
+	c.SetWithTags(ctx, "query.1", 1, time.Minute, "user:1")
+	c.SetWithTags(ctx, "query.2", 2, time.Minute, "user:1", "user:2")
+	if len(c.tags["user:1"]) != 1 {
+		t.Errorf("evicted key should be removed from tag but got %v", c.tags["user:1"])
+	}
+	c.Del(ctx, "query.2")
+	if len(c.tags) != 0 {
+		t.Errorf("tags without keys should be removed but got %v", c.tags)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/ofavor/ddd-go/pkg/cache"
@@ -12,46 +14,113 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+// Redis cache interface
+type Cache interface {
+	cache.ExtendedCache
+	cache.TaggedCache
+}
+
+// Redis cache option
+type Option func(c *redisCache)
+
+// Set codec of values, values are raw JSON without header by default
+func WithCodec(codec cache.Codec) Option {
+	return func(c *redisCache) {
+		if c.encoder == nil {
+			c.encoder = &cache.Encoder{}
+		}
+		c.encoder.Codec = codec
+	}
+}
+
+// Compress values not smaller than threshold bytes, JSON codec is used if no codec is set
+func WithCompression(comp cache.Compression, threshold int) Option {
+	return func(c *redisCache) {
+		if c.encoder == nil {
+			c.encoder = &cache.Encoder{Codec: cache.JSONCodec}
+		}
+		c.encoder.Compression = comp
+		c.encoder.Threshold = threshold
+	}
+}
+
 // redisCache cache redis implementation
 type redisCache struct {
-	conn   *redis.Client
-	prefix string
+	conn       redis.UniversalClient
+	prefix     string
+	encoder    *cache.Encoder
+	jitter     float64
+	staleTTL   time.Duration
+	refreshers map[string]Refresher
+	refreshing sync.Map
 }
 
 // NewCache create redis cache
-func NewCache(addr, password string, db int32, prefix string) cache.Cache {
+func NewCache(addr, password string, db int32, prefix string, opts ...Option) Cache {
 	log.Debug("[cache-redis] connect to ", addr)
 	conn := redis.NewClient(&redis.Options{
 		Addr:     addr,
 		Password: password,
 		DB:       int(db),
 	})
-	return NewCacheWithConn(conn, prefix)
+	return NewCacheWithConn(conn, prefix, opts...)
 }
 
-func NewCacheWithConn(conn *redis.Client, prefix string) cache.Cache {
-	return &redisCache{
+// NewCacheWithOptions create redis cache with universal options, multiple addrs connect to a cluster,
+// a master name connects to sentinel
+func NewCacheWithOptions(options *redis.UniversalOptions, prefix string, opts ...Option) Cache {
+	log.Debug("[cache-redis] connect to ", options.Addrs)
+	return NewCacheWithConn(redis.NewUniversalClient(options), prefix, opts...)
+}
+
+// NewCacheWithConn create redis cache with an existing client, cluster and failover clients are supported
+func NewCacheWithConn(conn redis.UniversalClient, prefix string, opts ...Option) Cache {
+	c := &redisCache{
 		conn:   conn,
 		prefix: prefix,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 func (c *redisCache) genKey(key string) string {
 	return fmt.Sprintf("%s:%s", c.prefix, key)
 }
 
-// Get connection returns *redis.Client
+// Tag set holds the keys with the tag, it lives until the tag is invalidated
+func (c *redisCache) genTagKey(tag string) string {
+	return c.genKey(fmt.Sprintf("__tag__:%s", tag))
+}
+
+func (c *redisCache) encode(value interface{}) ([]byte, error) {
+	if c.encoder == nil {
+		return json.Marshal(value)
+	}
+	return c.encoder.Encode(value)
+}
+
+// Get connection returns redis.UniversalClient
 func (c *redisCache) GetConn() interface{} {
 	return c.conn
 }
 
+// Codec implements cache.CodecProvider.
+func (c *redisCache) Codec() cache.Codec {
+	if c.encoder == nil {
+		return cache.JSONCodec
+	}
+	return c.encoder.Codec
+}
+
 // Set value to cache
 func (c *redisCache) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
-	j, err := json.Marshal(value)
+	j, expiration, err := c.encodeWithTTL(value, expiration)
 	if err != nil {
 		return err
 	}
-	log.Debugf("[cache-redis] set %s = %s", key, j)
+	log.Debugf("[cache-redis] set %s = %q", key, j)
 	err = c.conn.Set(ctx, c.genKey(key), string(j), expiration).Err()
 	if err != nil {
 		log.Warnf("[cache-redis] set (%s) failed: %v", key, err)
@@ -62,7 +131,7 @@ func (c *redisCache) Set(ctx context.Context, key string, value interface{}, exp
 // Get value from cache
 func (c *redisCache) Get(ctx context.Context, key string, out interface{}) error {
 	val, err := c.conn.Get(ctx, c.genKey(key)).Result()
-	log.Debugf("[cache-redis] get %s = %s", key, val)
+	log.Debugf("[cache-redis] get %s = %q", key, val)
 	if err != nil {
 		if err == redis.Nil {
 			//log.Warnf("[cache-redis] get (%s) failed: %v", key, err)
@@ -70,7 +139,7 @@ func (c *redisCache) Get(ctx context.Context, key string, out interface{}) error
 		}
 		return err
 	}
-	return json.Unmarshal([]byte(val), out)
+	return cache.Decode(c.unwrap(key, []byte(val)), out)
 }
 
 // Delete value from cache
@@ -79,9 +148,261 @@ func (c *redisCache) Del(ctx context.Context, key ...string) error {
 	for _, k := range key {
 		nkeys = append(nkeys, c.genKey(k))
 	}
-	err := c.conn.Del(ctx, nkeys...).Err()
+	err := c.del(ctx, nkeys...)
 	if err != nil {
 		log.Warnf("[cache-redis] delete (%s) failed: %v", key, err)
 	}
 	return err
 }
+
+// Multi-key commands fail with CROSSSLOT on cluster, keys are sent one by one in a pipeline instead
+func (c *redisCache) del(ctx context.Context, keys ...string) error {
+	if _, ok := c.conn.(*redis.ClusterClient); !ok {
+		return c.conn.Del(ctx, keys...).Err()
+	}
+	_, err := c.conn.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, k := range keys {
+			p.Del(ctx, k)
+		}
+		return nil
+	})
+	return err
+}
+
+// MGet implements cache.ExtendedCache.
+func (c *redisCache) MGet(ctx context.Context, keys []string, out interface{}) error {
+	cmds := make([]*redis.StringCmd, 0, len(keys))
+	_, err := c.conn.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, k := range keys {
+			cmds = append(cmds, p.Get(ctx, c.genKey(k)))
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		log.Warnf("[cache-redis] mget (%s) failed: %v", keys, err)
+		return err
+	}
+	values := make(map[string][]byte, len(keys))
+	for i, cmd := range cmds {
+		val, err := cmd.Bytes()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		values[keys[i]] = c.unwrap(keys[i], val)
+	}
+	return cache.FillMap(out, values, cache.Decode)
+}
+
+// MSet implements cache.ExtendedCache.
+func (c *redisCache) MSet(ctx context.Context, values map[string]interface{}, expiration time.Duration) error {
+	type entry struct {
+		data string
+		ttl  time.Duration
+	}
+	encoded := make(map[string]entry, len(values))
+	for k, v := range values {
+		j, ttl, err := c.encodeWithTTL(v, expiration)
+		if err != nil {
+			return err
+		}
+		encoded[k] = entry{string(j), ttl}
+	}
+	_, err := c.conn.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for k, e := range encoded {
+			p.Set(ctx, c.genKey(k), e.data, e.ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Warnf("[cache-redis] mset failed: %v", err)
+	}
+	return err
+}
+
+// Exists implements cache.ExtendedCache.
+func (c *redisCache) Exists(ctx context.Context, keys ...string) (int64, error) {
+	nkeys := make([]string, 0, len(keys))
+	for _, k := range keys {
+		nkeys = append(nkeys, c.genKey(k))
+	}
+	if _, ok := c.conn.(*redis.ClusterClient); !ok {
+		return c.conn.Exists(ctx, nkeys...).Result()
+	}
+	cmds := make([]*redis.IntCmd, 0, len(nkeys))
+	_, err := c.conn.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, k := range nkeys {
+			cmds = append(cmds, p.Exists(ctx, k))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, cmd := range cmds {
+		n += cmd.Val()
+	}
+	return n, nil
+}
+
+// TTL implements cache.ExtendedCache.
+func (c *redisCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := c.conn.PTTL(ctx, c.genKey(key)).Result()
+	if err != nil {
+		return 0, err
+	}
+	switch ttl {
+	case -2: // key does not exist
+		return 0, cache.ErrNil
+	case -1: // key never expires
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// Expire implements cache.ExtendedCache.
+func (c *redisCache) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	var ok bool
+	var err error
+	if expiration > 0 {
+		ok, err = c.conn.PExpire(ctx, c.genKey(key), expiration).Result()
+	} else {
+		// PERSIST returns false for keys without expiration as well
+		if ok, err = c.conn.Persist(ctx, c.genKey(key)).Result(); err == nil && !ok {
+			var n int64
+			n, err = c.conn.Exists(ctx, c.genKey(key)).Result()
+			ok = n > 0
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return cache.ErrNil
+	}
+	return nil
+}
+
+// Incr implements cache.ExtendedCache.
+func (c *redisCache) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	return c.conn.IncrBy(ctx, c.genKey(key), delta).Result()
+}
+
+// Decr implements cache.ExtendedCache.
+func (c *redisCache) Decr(ctx context.Context, key string, delta int64) (int64, error) {
+	return c.conn.DecrBy(ctx, c.genKey(key), delta).Result()
+}
+
+// SetNX implements cache.ExtendedCache.
+func (c *redisCache) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	j, expiration, err := c.encodeWithTTL(value, expiration)
+	if err != nil {
+		return false, err
+	}
+	return c.conn.SetNX(ctx, c.genKey(key), string(j), expiration).Result()
+}
+
+// Number of keys handled in one round while invalidating
+const invalidateBatchSize = 100
+
+// Add key to tag set and keep the set alive at least as long as its members, ARGV: key, ttl in ms (0 never expires).
+// Members of expired keys are dropped with the set, a set with a member never expiring is persistent.
+var tagScript = redis.NewScript(`
+local existed = redis.call('exists', KEYS[1])
+redis.call('sadd', KEYS[1], ARGV[1])
+local ttl = tonumber(ARGV[2])
+if ttl <= 0 then
+	redis.call('persist', KEYS[1])
+elseif existed == 0 then
+	redis.call('pexpire', KEYS[1], ttl)
+else
+	local cur = redis.call('pttl', KEYS[1])
+	if cur >= 0 and cur < ttl then
+		redis.call('pexpire', KEYS[1], ttl)
+	end
+end
+return 1
+`)
+
+// SetWithTags implements cache.TaggedCache, tag sets expire after the last of their keys.
+func (c *redisCache) SetWithTags(ctx context.Context, key string, value interface{}, expiration time.Duration, tags ...string) error {
+	j, expiration, err := c.encodeWithTTL(value, expiration)
+	if err != nil {
+		return err
+	}
+	log.Debugf("[cache-redis] set %s = %q with tags %v", key, j, tags)
+	_, err = c.conn.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, c.genKey(key), string(j), expiration)
+		for _, tag := range tags {
+			tagScript.Eval(ctx, p, []string{c.genTagKey(tag)}, key, expiration.Milliseconds())
+		}
+		return nil
+	})
+	if err != nil {
+		log.Warnf("[cache-redis] set (%s) with tags failed: %v", key, err)
+	}
+	return err
+}
+
+// InvalidateTags implements cache.TaggedCache.
+func (c *redisCache) InvalidateTags(ctx context.Context, tags ...string) error {
+	for _, tag := range tags {
+		// SPOP removes keys atomically, keys tagged concurrently are left for the next invalidation
+		for {
+			keys, err := c.conn.SPopN(ctx, c.genTagKey(tag), invalidateBatchSize).Result()
+			if err != nil {
+				log.Warnf("[cache-redis] invalidate tag (%s) failed: %v", tag, err)
+				return err
+			}
+			if len(keys) == 0 {
+				break
+			}
+			if err := c.Del(ctx, keys...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// DelByPrefix implements cache.TaggedCache, keys are scanned in batches, KEYS is never used.
+// On cluster every master is scanned.
+func (c *redisCache) DelByPrefix(ctx context.Context, prefix string) error {
+	match := globEscaper.Replace(c.genKey(prefix)) + "*"
+	var err error
+	if cc, ok := c.conn.(*redis.ClusterClient); ok {
+		err = cc.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return c.delByMatch(ctx, node, match)
+		})
+	} else {
+		err = c.delByMatch(ctx, c.conn, match)
+	}
+	if err != nil {
+		log.Warnf("[cache-redis] delete by prefix (%s) failed: %v", prefix, err)
+	}
+	return err
+}
+
+func (c *redisCache) delByMatch(ctx context.Context, conn redis.Cmdable, match string) error {
+	var cursor uint64
+	for {
+		keys, next, err := conn.Scan(ctx, cursor, match, invalidateBatchSize).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := c.del(ctx, keys...); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
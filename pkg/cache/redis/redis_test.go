@@ -3,10 +3,13 @@ package redis
 import (
 	"context"
 	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/ofavor/ddd-go/pkg/cache"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redismock/v9"
 	"github.com/redis/go-redis/v9"
 )
@@ -198,3 +201,216 @@ func TestDelFailed(t *testing.T) {
 		t.Errorf("expected error 'some error' but got '%s'", err.Error())
 	}
 }
+
+func TestCodec(t *testing.T) {
+	conn, mock := redismock.NewClientMock()
+	red := NewCacheWithConn(conn, "myprefix", WithCodec(cache.MsgpackCodec), WithCompression(cache.Snappy, 1024)).(*redisCache)
+	ctx := context.Background()
+
+	key := "key.test"
+	data, _ := red.encoder.Encode("hello")
+	if data[0] != 0x80|cache.MsgpackCodec.Id() {
+		t.Errorf("expected msgpack header but got 0x%x", data[0])
+	}
+	mock.ExpectSet(red.genKey(key), string(data), 0).SetVal("OK")
+	if err := red.Set(ctx, key, "hello", 0); err != nil {
+		t.Errorf("no error expected for Set but got '%v'", err)
+	}
+
+	var out string
+	mock.ExpectGet(red.genKey(key)).SetVal(string(data))
+	if err := red.Get(ctx, key, &out); err != nil || out != "hello" {
+		t.Errorf("expected 'hello' but got '%s', '%v'", out, err)
+	}
+
+	// values written before migrating to a codec are still readable
+	mock.ExpectGet(red.genKey(key)).SetVal("\"legacy\"")
+	if err := red.Get(ctx, key, &out); err != nil || out != "legacy" {
+		t.Errorf("expected 'legacy' but got '%s', '%v'", out, err)
+	}
+}
+
+func newMiniredisCache(t *testing.T) (*redisCache, *miniredis.Miniredis) {
+	s := miniredis.RunT(t)
+	conn := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	t.Cleanup(func() { conn.Close() })
+	return NewCacheWithConn(conn, "myprefix").(*redisCache), s
+}
+
+func TestMGetMSet(t *testing.T) {
+	red, _ := newMiniredisCache(t)
+	ctx := context.Background()
+	if err := red.MSet(ctx, map[string]interface{}{"key.1": 1, "key.2": 2}, time.Minute); err != nil {
+		t.Errorf("no error expected for MSet but got '%v'", err)
+	}
+	out := map[string]int{}
+	if err := red.MGet(ctx, []string{"key.1", "key.2", "key.3"}, &out); err != nil {
+		t.Errorf("no error expected for MGet but got '%v'", err)
+	}
+	if len(out) != 2 || out["key.1"] != 1 || out["key.2"] != 2 {
+		t.Errorf("expected 'key.1=1,key.2=2' but got '%v'", out)
+	}
+}
+
+func TestExistsTTLExpire(t *testing.T) {
+	red, s := newMiniredisCache(t)
+	ctx := context.Background()
+	red.Set(ctx, "key.1", 1, 0)
+	red.Set(ctx, "key.2", 2, time.Minute)
+	if n, err := red.Exists(ctx, "key.1", "key.2", "key.3"); err != nil || n != 2 {
+		t.Errorf("expected 2 existing keys but got %d, '%v'", n, err)
+	}
+	if ttl, err := red.TTL(ctx, "key.1"); err != nil || ttl != 0 {
+		t.Errorf("expected no expiration but got %s, '%v'", ttl, err)
+	}
+	if ttl, err := red.TTL(ctx, "key.2"); err != nil || ttl != time.Minute {
+		t.Errorf("expected ttl 1m but got %s, '%v'", ttl, err)
+	}
+	if _, err := red.TTL(ctx, "key.3"); err != cache.ErrNil {
+		t.Errorf("expected error 'cache is nil' but got '%v'", err)
+	}
+	if err := red.Expire(ctx, "key.1", time.Second); err != nil {
+		t.Errorf("no error expected for Expire but got '%v'", err)
+	}
+	if err := red.Expire(ctx, "key.2", 0); err != nil {
+		t.Errorf("no error expected for Expire but got '%v'", err)
+	}
+	if err := red.Expire(ctx, "key.2", 0); err != nil {
+		t.Errorf("no error expected for Expire on key without expiration but got '%v'", err)
+	}
+	if err := red.Expire(ctx, "key.3", time.Second); err != cache.ErrNil {
+		t.Errorf("expected error 'cache is nil' but got '%v'", err)
+	}
+	s.FastForward(2 * time.Second)
+	if n, _ := red.Exists(ctx, "key.1", "key.2"); n != 1 {
+		t.Errorf("expected 1 existing key but got %d", n)
+	}
+}
+
+func TestIncrDecr(t *testing.T) {
+	red, _ := newMiniredisCache(t)
+	ctx := context.Background()
+	if n, err := red.Incr(ctx, "counter", 5); err != nil || n != 5 {
+		t.Errorf("expected 5 but got %d, '%v'", n, err)
+	}
+	if n, err := red.Decr(ctx, "counter", 2); err != nil || n != 3 {
+		t.Errorf("expected 3 but got %d, '%v'", n, err)
+	}
+	var out int
+	if err := red.Get(ctx, "counter", &out); err != nil || out != 3 {
+		t.Errorf("expected 3 but got %d, '%v'", out, err)
+	}
+}
+
+func TestSetNX(t *testing.T) {
+	red, _ := newMiniredisCache(t)
+	ctx := context.Background()
+	if ok, err := red.SetNX(ctx, "key.1", "v1", 0); err != nil || !ok {
+		t.Errorf("value should be set for new key but got '%v'", err)
+	}
+	if ok, err := red.SetNX(ctx, "key.1", "v2", 0); err != nil || ok {
+		t.Errorf("value should not be set for existing key but got '%v'", err)
+	}
+	var out string
+	red.Get(ctx, "key.1", &out)
+	if out != "v1" {
+		t.Errorf("expected 'v1' but got '%s'", out)
+	}
+}
+
+func TestTags(t *testing.T) {
+	red, s := newMiniredisCache(t)
+	ctx := context.Background()
+	red.SetWithTags(ctx, "query.1", 1, time.Minute, "user:1", "user:2")
+	red.SetWithTags(ctx, "query.2", 2, time.Minute, "user:2")
+	red.SetWithTags(ctx, "query.3", 3, time.Minute, "user:3")
+	if err := red.InvalidateTags(ctx, "user:2"); err != nil {
+		t.Errorf("no error expected for InvalidateTags but got '%v'", err)
+	}
+	if n, _ := red.Exists(ctx, "query.1", "query.2", "query.3"); n != 1 {
+		t.Errorf("expected 1 existing key but got %d", n)
+	}
+	if s.Exists(red.genTagKey("user:2")) {
+		t.Error("tag set should be deleted")
+	}
+}
+
+func TestDelByPrefix(t *testing.T) {
+	red, s := newMiniredisCache(t)
+	ctx := context.Background()
+	// miniredis cursors are offsets of sorted keys, deleting while scanning skips keys there (not in redis)
+	for i := 0; i < invalidateBatchSize/2; i++ {
+		red.Set(ctx, fmt.Sprintf("user:%d", i), i, 0)
+	}
+	red.Set(ctx, "user*:x", 1, 0)
+	red.Set(ctx, "order:1", 1, 0)
+	s.Set("otherprefix:user:1", "1")
+	if err := red.DelByPrefix(ctx, "user:"); err != nil {
+		t.Errorf("no error expected for DelByPrefix but got '%v'", err)
+	}
+	if n, _ := red.Exists(ctx, "user*:x", "order:1"); n != 2 {
+		t.Errorf("keys without the prefix should be kept but got %d", n)
+	}
+	if !s.Exists("otherprefix:user:1") {
+		t.Error("keys of other cache prefix should be kept")
+	}
+	if len(s.Keys()) != 3 {
+		t.Errorf("expected 3 keys left but got %d", len(s.Keys()))
+	}
+	red.DelByPrefix(ctx, "user*")
+	if n, _ := red.Exists(ctx, "user*:x"); n != 0 {
+		t.Error("glob characters in prefix should be matched literally")
+	}
+}
+
+func TestClusterCache(t *testing.T) {
+	s := miniredis.RunT(t)
+	// multiple addrs select cluster mode
+	red := NewCacheWithOptions(&redis.UniversalOptions{Addrs: []string{s.Addr(), s.Addr()}}, "myprefix")
+	t.Cleanup(func() { red.(*redisCache).conn.Close() })
+	if _, ok := red.(*redisCache).conn.(*redis.ClusterClient); !ok {
+		t.Fatalf("cluster client expected")
+	}
+	ctx := context.Background()
+	for _, k := range []string{"user:1", "user:2", "order:1"} {
+		if err := red.Set(ctx, k, k, time.Minute); err != nil {
+			t.Fatalf("no error expected for Set but got '%v'", err)
+		}
+	}
+	if n, err := red.Exists(ctx, "user:1", "user:2", "user:3"); err != nil || n != 2 {
+		t.Errorf("2 keys expected to exist but got %d, %v", n, err)
+	}
+	if err := red.Del(ctx, "user:1", "order:1"); err != nil {
+		t.Errorf("no error expected for Del but got '%v'", err)
+	}
+	if s.Exists("myprefix:user:1") || s.Exists("myprefix:order:1") {
+		t.Errorf("keys should be deleted")
+	}
+	if err := red.DelByPrefix(ctx, "user:"); err != nil {
+		t.Errorf("no error expected for DelByPrefix but got '%v'", err)
+	}
+	if s.Exists("myprefix:user:2") {
+		t.Errorf("key should be deleted by prefix")
+	}
+}
+
+func TestTagExpiration(t *testing.T) {
+	red, s := newMiniredisCache(t)
+	ctx := context.Background()
+	red.SetWithTags(ctx, "query.1", 1, time.Minute, "user:1")
+	red.SetWithTags(ctx, "query.2", 2, time.Hour, "user:1")
+	red.SetWithTags(ctx, "query.3", 3, time.Second, "user:1")
+	if ttl := s.TTL(red.genTagKey("user:1")); ttl != time.Hour {
+		t.Errorf("tag set expected to live as long as its longest key but got %v", ttl)
+	}
+	red.SetWithTags(ctx, "query.4", 4, 0, "user:1")
+	if ttl := s.TTL(red.genTagKey("user:1")); ttl != 0 {
+		t.Errorf("tag set with a key never expiring expected to be persistent but got %v", ttl)
+	}
+	s.FastForward(time.Minute)
+	red.SetWithTags(ctx, "query.5", 5, time.Second, "user:2")
+	s.FastForward(2 * time.Second)
+	if s.Exists(red.genTagKey("user:2")) {
+		t.Error("tag set should expire with its keys")
+	}
+}
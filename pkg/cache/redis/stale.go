@@ -0,0 +1,137 @@
+package redis
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/cache"
+	"github.com/ofavor/ddd-go/pkg/log"
+)
+
+// Refresher reloads the value of key when it is softly expired, return cache.ErrNotFound to drop the key
+type Refresher func(ctx context.Context, key string) (interface{}, error)
+
+// Set randomized TTL jitter, expirations are extended by a random duration up to fraction of themselves,
+// so that keys written together do not expire together
+func WithTTLJitter(fraction float64) Option {
+	return func(c *redisCache) {
+		c.jitter = fraction
+	}
+}
+
+// Enable soft expiry, values are kept for staleTTL after expiration and Get keeps returning them
+// while a single background refresh repopulates the key.
+// Values are wrapped in an envelope in this mode, counters of Incr/Decr must not share keys with them.
+func WithSoftExpiry(staleTTL time.Duration) Option {
+	return func(c *redisCache) {
+		c.staleTTL = staleTTL
+	}
+}
+
+// Register refresher of keys with prefix, the longest matching prefix wins.
+// Stale values of keys without refresher are served until they are removed.
+func WithRefresher(prefix string, r Refresher) Option {
+	return func(c *redisCache) {
+		if c.refreshers == nil {
+			c.refreshers = map[string]Refresher{}
+		}
+		c.refreshers[prefix] = r
+	}
+}
+
+// Envelope of softly expiring values: marker, soft deadline (unix ms), ttl (ms), payload.
+// The marker is neither a codec header nor a valid first byte of JSON.
+const (
+	envelopeMarker = 0x7f
+	envelopeSize   = 17
+)
+
+// Refresh must finish within the timeout, it is the TTL of the refresh lock as well
+const refreshTimeout = 30 * time.Second
+
+func (c *redisCache) jittered(expiration time.Duration) time.Duration {
+	if expiration <= 0 || c.jitter <= 0 {
+		return expiration
+	}
+	return expiration + time.Duration(rand.Int63n(int64(float64(expiration)*c.jitter)+1))
+}
+
+// Encode value for writing, the actual expiration of key is returned
+func (c *redisCache) encodeWithTTL(value interface{}, expiration time.Duration) ([]byte, time.Duration, error) {
+	j, err := c.encode(value)
+	if err != nil {
+		return nil, 0, err
+	}
+	jittered := c.jittered(expiration)
+	if c.staleTTL <= 0 || expiration <= 0 {
+		return j, jittered, nil
+	}
+	// the requested expiration is kept for refresh, so that jitter does not accumulate
+	data := make([]byte, envelopeSize, envelopeSize+len(j))
+	data[0] = envelopeMarker
+	binary.BigEndian.PutUint64(data[1:], uint64(time.Now().Add(jittered).UnixMilli()))
+	binary.BigEndian.PutUint64(data[9:], uint64(expiration.Milliseconds()))
+	return append(data, j...), jittered + c.staleTTL, nil
+}
+
+// Unwrap envelope, a refresh is started if the value is softly expired
+func (c *redisCache) unwrap(key string, data []byte) []byte {
+	if len(data) < envelopeSize || data[0] != envelopeMarker {
+		return data
+	}
+	deadline := time.UnixMilli(int64(binary.BigEndian.Uint64(data[1:])))
+	if time.Now().After(deadline) {
+		ttl := time.Duration(binary.BigEndian.Uint64(data[9:])) * time.Millisecond
+		c.refresh(key, ttl)
+	}
+	return data[envelopeSize:]
+}
+
+func (c *redisCache) refresher(key string) Refresher {
+	var r Refresher
+	matched := -1
+	for prefix, f := range c.refreshers {
+		if strings.HasPrefix(key, prefix) && len(prefix) > matched {
+			r, matched = f, len(prefix)
+		}
+	}
+	return r
+}
+
+// Refresh key in background, concurrent refreshes are deduplicated in process by a map
+// and across instances by a lock in redis
+func (c *redisCache) refresh(key string, ttl time.Duration) {
+	r := c.refresher(key)
+	if r == nil {
+		return
+	}
+	if _, loaded := c.refreshing.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+	go func() {
+		defer c.refreshing.Delete(key)
+		ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+		defer cancel()
+		lockKey := c.genKey("__refresh__:" + key)
+		ok, err := c.conn.SetNX(ctx, lockKey, 1, refreshTimeout).Result()
+		if err != nil || !ok {
+			return
+		}
+		defer c.conn.Del(context.Background(), lockKey)
+		log.Debugf("[cache-redis] refresh %s", key)
+		v, err := r(ctx, key)
+		if errors.Is(err, cache.ErrNotFound) {
+			c.Del(ctx, key)
+			return
+		}
+		if err != nil {
+			log.Warnf("[cache-redis] refresh (%s) failed: %v", key, err)
+			return
+		}
+		c.Set(ctx, key, v, ttl)
+	}()
+}
@@ -0,0 +1,115 @@
+package redis
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ofavor/ddd-go/pkg/cache"
+	"github.com/redis/go-redis/v9"
+)
+
+func newMiniredisCacheWithOptions(t *testing.T, opts ...Option) (*redisCache, *miniredis.Miniredis) {
+	s := miniredis.RunT(t)
+	conn := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	t.Cleanup(func() { conn.Close() })
+	return NewCacheWithConn(conn, "myprefix", opts...).(*redisCache), s
+}
+
+func TestTTLJitter(t *testing.T) {
+	red, s := newMiniredisCacheWithOptions(t, WithTTLJitter(0.5))
+	ctx := context.Background()
+	if err := red.MSet(ctx, map[string]interface{}{"k1": 1, "k2": 2, "k3": 3}, time.Minute); err != nil {
+		t.Fatalf("no error expected for MSet but got '%v'", err)
+	}
+	for _, k := range []string{"myprefix:k1", "myprefix:k2", "myprefix:k3"} {
+		if ttl := s.TTL(k); ttl < time.Minute || ttl > 90*time.Second {
+			t.Errorf("ttl of %s expected within [1m, 1m30s] but got %v", k, ttl)
+		}
+	}
+	if err := red.Set(ctx, "k4", 4, 0); err != nil {
+		t.Fatalf("no error expected for Set but got '%v'", err)
+	}
+	if ttl := s.TTL("myprefix:k4"); ttl != 0 {
+		t.Errorf("no ttl expected for key without expiration but got %v", ttl)
+	}
+}
+
+func TestSoftExpiry(t *testing.T) {
+	var loads int32
+	release := make(chan struct{})
+	red, s := newMiniredisCacheWithOptions(t,
+		WithSoftExpiry(time.Minute),
+		WithRefresher("user:", func(ctx context.Context, key string) (interface{}, error) {
+			atomic.AddInt32(&loads, 1)
+			<-release
+			return "fresh", nil
+		}))
+	ctx := context.Background()
+	if err := red.Set(ctx, "user:1", "stale", 50*time.Millisecond); err != nil {
+		t.Fatalf("no error expected for Set but got '%v'", err)
+	}
+	if ttl := s.TTL("myprefix:user:1"); ttl != time.Minute+50*time.Millisecond {
+		t.Errorf("hard ttl expected to include stale ttl but got %v", ttl)
+	}
+	var v string
+	if err := red.Get(ctx, "user:1", &v); err != nil || v != "stale" {
+		t.Errorf("'stale' expected but got '%s', %v", v, err)
+	}
+	time.Sleep(80 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		if err := red.Get(ctx, "user:1", &v); err != nil || v != "stale" {
+			t.Errorf("stale value expected while refreshing but got '%s', %v", v, err)
+		}
+	}
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for v != "fresh" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		red.Get(ctx, "user:1", &v)
+	}
+	if v != "fresh" {
+		t.Errorf("refreshed value expected but got '%s'", v)
+	}
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Errorf("single refresh expected but got %d", n)
+	}
+	if s.Exists("myprefix:__refresh__:user:1") {
+		t.Errorf("refresh lock should be released")
+	}
+}
+
+func TestSoftExpiryNotFound(t *testing.T) {
+	red, s := newMiniredisCacheWithOptions(t,
+		WithSoftExpiry(time.Minute),
+		WithRefresher("user:", func(ctx context.Context, key string) (interface{}, error) {
+			return nil, cache.ErrNotFound
+		}))
+	ctx := context.Background()
+	red.Set(ctx, "user:1", "gone", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	var v string
+	if err := red.Get(ctx, "user:1", &v); err != nil || v != "gone" {
+		t.Errorf("stale value expected but got '%s', %v", v, err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for s.Exists("myprefix:user:1") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.Exists("myprefix:user:1") {
+		t.Errorf("key should be dropped when refresher reports not found")
+	}
+}
+
+func TestSoftExpiryWithoutRefresher(t *testing.T) {
+	red, _ := newMiniredisCacheWithOptions(t, WithSoftExpiry(time.Minute))
+	ctx := context.Background()
+	red.MSet(ctx, map[string]interface{}{"order:1": 1}, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	out := map[string]int{}
+	if err := red.MGet(ctx, []string{"order:1"}, &out); err != nil || out["order:1"] != 1 {
+		t.Errorf("stale value expected from MGet but got %v, %v", out, err)
+	}
+}
@@ -0,0 +1,123 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Dead letter, the event failed all attempts of a subscriber
+type DeadLetter struct {
+	// Id assigned by dead letter queue
+	Id         string
+	Event      *Event
+	Subscriber string
+	Error      string
+	Attempts   int
+	Time       time.Time
+}
+
+type deadLetterJSON struct {
+	EventId    string          `json:"event_id"`
+	Type       string          `json:"type"`
+	Key        string          `json:"key,omitempty"`
+	Headers    Headers         `json:"headers,omitempty"`
+	EventTime  int64           `json:"event_time"`
+	Payload    json.RawMessage `json:"payload"`
+	Subscriber string          `json:"subscriber"`
+	Error      string          `json:"error"`
+	Attempts   int             `json:"attempts"`
+	Time       int64           `json:"time"`
+}
+
+// MarshalJSON implements json.Marshaler, Id is not included
+func (d *DeadLetter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(deadLetterJSON{
+		EventId:    d.Event.Id().String(),
+		Type:       d.Event.Meta().Type,
+		Key:        d.Event.Meta().Key,
+		Headers:    d.Event.Meta().Headers,
+		EventTime:  d.Event.Meta().Time.UnixNano(),
+		Payload:    d.Event.Payload(),
+		Subscriber: d.Subscriber,
+		Error:      d.Error,
+		Attempts:   d.Attempts,
+		Time:       d.Time.UnixNano(),
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *DeadLetter) UnmarshalJSON(data []byte) error {
+	j := deadLetterJSON{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	e, err := LoadEvent(j.EventId, j.EventTime, j.Type, string(j.Payload), WithKey(j.Key), WithHeaders(j.Headers))
+	if err != nil {
+		return err
+	}
+	d.Event = e
+	d.Subscriber = j.Subscriber
+	d.Error = j.Error
+	d.Attempts = j.Attempts
+	d.Time = time.Unix(0, j.Time)
+	return nil
+}
+
+// Dead letter destination
+type DeadLetterSink interface {
+	// Put dead letter, Id is assigned on success if the sink supports lookup
+	Put(ctx context.Context, dl *DeadLetter) error
+}
+
+// Dead letter queue supports inspection
+type DeadLetterQueue interface {
+	DeadLetterSink
+
+	// List dead letters from the oldest one, limit <= 0 means no limit
+	List(ctx context.Context, limit int) ([]*DeadLetter, error)
+
+	// Delete dead letter by Id
+	Delete(ctx context.Context, id string) error
+}
+
+// Replay dead letters of subscriber with handler, replayed ones are deleted from queue.
+// Dead letters failed again are kept, the number of replayed ones and the first error are returned.
+// It is the same as Dispatcher.Replay without middlewares of bus.
+func Replay(ctx context.Context, q DeadLetterQueue, subscriber string, h EventHandler, limit int, opts ...SubscribeOption) (int, error) {
+	return new(Dispatcher).Replay(ctx, q, subscriber, h, limit, opts...)
+}
+
+// Replay dead letters of subscriber like the events dispatched to its subscription with options,
+// so that middlewares, retries and inbox apply. Dead letter sink of options is ignored, dead letters failed again
+// are kept in queue. Replayed ones are deleted, the number of them and the first error are returned.
+func (d *Dispatcher) Replay(ctx context.Context, q DeadLetterQueue, subscriber string, h EventHandler, limit int, opts ...SubscribeOption) (int, error) {
+	dls, err := q.List(ctx, 0)
+	if err != nil {
+		return 0, err
+	}
+	o := NewSubscribeOptions(opts...)
+	o.DeadLetter = nil
+	n := 0
+	var first error
+	for _, dl := range dls {
+		if limit > 0 && n >= limit {
+			break
+		}
+		if dl.Subscriber != subscriber {
+			continue
+		}
+		err := d.Dispatch(ctx, dl.Event, subscriber, h, o)
+		if err == nil {
+			err = q.Delete(ctx, dl.Id)
+		}
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		n++
+	}
+	return n, first
+}
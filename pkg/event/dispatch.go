@@ -0,0 +1,175 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+)
+
+// Dispatcher handles events with middlewares, retries and dead letters. Event buses embed it,
+// so that middlewares used on a bus apply to all of its subscriptions. The zero value is ready to use.
+type Dispatcher struct {
+	middlewares []Middleware
+	lock        sync.RWMutex
+}
+
+// Use middlewares for all subscriptions, they wrap the middlewares of subscription
+func (d *Dispatcher) Use(mws ...Middleware) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.middlewares = append(d.middlewares, mws...)
+}
+
+// Dispatch event to handler of subscriber through middlewares and inbox, see Handle
+func (d *Dispatcher) Dispatch(ctx context.Context, e *Event, subscriber string, h EventHandler, o *SubscribeOptions) error {
+	d.lock.RLock()
+	mws := append(append([]Middleware{}, d.middlewares...), o.Middlewares...)
+	d.lock.RUnlock()
+	if o.Inbox != nil {
+		mws = append(mws, inboxMiddleware(o.Inbox))
+	}
+	return handle(context.WithValue(ctx, subscriberKey{}, subscriber), e, subscriber, Chain(h, mws...), o)
+}
+
+type subscriberKey struct{}
+
+// Get name of the subscriber handling event from context
+func SubscriberFromContext(ctx context.Context) string {
+	s, _ := ctx.Value(subscriberKey{}).(string)
+	return s
+}
+
+// Error of events delivered to a stopped subscription
+var ErrSubscriptionStopped = errors.New("subscription stopped")
+
+// Number of events buffered by each worker of subscription
+const subscriptionBufferSize = 100
+
+// Event delivered to a subscription worker, the result is reported when handling is done
+type delivery struct {
+	e      *Event
+	result chan error
+}
+
+// Subscription of a handler, events are dispatched to workers sharded by key, see WithConcurrency.
+// It is shared by event bus implementations.
+type Subscription struct {
+	Type       string
+	Name       string
+	Options    *SubscribeOptions
+	handler    EventHandler
+	callback   uintptr
+	dispatcher *Dispatcher
+	workers    []chan *delivery
+	stopped    bool
+	lock       sync.Mutex
+}
+
+// Create subscription dispatched by d, it is started by Start
+func (d *Dispatcher) NewSubscription(t, name string, h EventHandler, opts ...SubscribeOption) *Subscription {
+	return &Subscription{
+		Type:       t,
+		Name:       name,
+		Options:    NewSubscribeOptions(opts...),
+		handler:    h,
+		callback:   reflect.ValueOf(h).Pointer(),
+		dispatcher: d,
+	}
+}
+
+// Start workers, handlers are called with ctx and wg is done when the workers exit
+func (s *Subscription) Start(ctx context.Context, wg *sync.WaitGroup) {
+	s.workers = make([]chan *delivery, s.Options.Concurrency)
+	for i := range s.workers {
+		events := make(chan *delivery, subscriptionBufferSize)
+		s.workers[i] = events
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range events {
+				d.result <- s.dispatcher.Dispatch(ctx, d.e, s.Name, s.handler, s.Options)
+			}
+		}()
+	}
+}
+
+// Deliver event to the worker of its key, the result is reported once it is handled.
+// Events delivered after Stop report ErrSubscriptionStopped.
+func (s *Subscription) Deliver(e *Event) <-chan error {
+	result := make(chan error, 1)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stopped {
+		result <- ErrSubscriptionStopped
+		return result
+	}
+	s.workers[e.Shard(len(s.workers))] <- &delivery{e: e, result: result}
+	return result
+}
+
+// Stop accepting events, the delivered ones are still handled before workers exit
+func (s *Subscription) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stopped {
+		return
+	}
+	s.stopped = true
+	for _, events := range s.workers {
+		close(events)
+	}
+}
+
+// Check if it is the subscription of handler h by subscriber name
+func (s *Subscription) Is(name string, h EventHandler) bool {
+	return s.Name == name && s.callback == reflect.ValueOf(h).Pointer()
+}
+
+// Lifecycle of the subscriptions started by an event bus. Handlers are called with its ctx,
+// which is canceled if closing times out.
+type Lifecycle struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Create lifecycle, see Close
+func NewLifecycle() *Lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Lifecycle{ctx: ctx, cancel: cancel}
+}
+
+// Start workers of subscription
+func (l *Lifecycle) Start(s *Subscription) {
+	s.Start(l.ctx, &l.wg)
+}
+
+// Close waits until consumed returns, i.e. the bus delivers no more events, then stops subscriptions by stop
+// and waits for their workers to handle the delivered events. If ctx is done meanwhile, handlers are canceled
+// and subscriptions are still stopped in background, so that workers exit once handlers return.
+func (l *Lifecycle) Close(ctx context.Context, consumed, stop func()) error {
+	defer l.cancel()
+	if err := wait(ctx, consumed); err != nil {
+		l.cancel()
+		go stop()
+		return err
+	}
+	stop()
+	return wait(ctx, l.wg.Wait)
+}
+
+// Wait until f returns or ctx is done
+func wait(ctx context.Context, f func()) error {
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -1,8 +1,11 @@
 package event
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,6 +15,35 @@ import (
 type Meta struct {
 	Type string
 	Time time.Time
+	// Ordering key, events with the same key are handled in order
+	Key string
+	// Headers, see Header constants for the well-known ones
+	Headers Headers
+}
+
+// Publish options
+type PublishOptions struct {
+	Key     string
+	Headers Headers
+	Context context.Context
+}
+
+// Publish option
+type PublishOption func(o *PublishOptions)
+
+// Set ordering key such as aggregate id, events with the same key are handled in publishing order by each handler
+func WithKey(key string) PublishOption {
+	return func(o *PublishOptions) {
+		o.Key = key
+	}
+}
+
+func newPublishOptions(opts []PublishOption) *PublishOptions {
+	o := &PublishOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
 }
 
 // Event
@@ -22,14 +54,17 @@ type Event struct {
 }
 
 // Create event
-func NewEvent(t string, data interface{}) (*Event, error) {
+func NewEvent(t string, data interface{}, opts ...PublishOption) (*Event, error) {
 	id, err := uuid.NewRandom()
 	if err != nil {
 		return nil, err
 	}
+	o := newPublishOptions(opts)
 	meta := Meta{
-		Type: t,
-		Time: time.Now(),
+		Type:    t,
+		Time:    time.Now(),
+		Key:     o.Key,
+		Headers: o.headers(id, t),
 	}
 	payload, err := json.Marshal(data)
 	if err != nil {
@@ -42,14 +77,20 @@ func NewEvent(t string, data interface{}) (*Event, error) {
 	}, nil
 }
 
-func LoadEvent(id string, tm int64, t string, data string) (*Event, error) {
+func LoadEvent(id string, tm int64, t string, data string, opts ...PublishOption) (*Event, error) {
 	uid, err := uuid.Parse(id)
 	if err != nil {
 		return nil, err
 	}
+	o := newPublishOptions(opts)
 	meta := Meta{
-		Type: t,
-		Time: time.Unix(0, tm),
+		Type:    t,
+		Time:    time.Unix(0, tm),
+		Key:     o.Key,
+		Headers: o.Headers,
+	}
+	if meta.Headers == nil {
+		meta.Headers = Headers{}
 	}
 	return &Event{
 		id:      uid,
@@ -73,22 +114,55 @@ func (e *Event) Payload() json.RawMessage {
 	return e.payload
 }
 
+// Get shard of event among n ones, events with the same key are in the same shard.
+// Events without key are spread by id.
+func (e *Event) Shard(n int) int {
+	if n <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	if e.meta.Key != "" {
+		h.Write([]byte(e.meta.Key))
+	} else {
+		h.Write(e.id[:])
+	}
+	return int(h.Sum32() % uint32(n))
+}
+
 // Get event string
 func (e *Event) String() string {
 	return fmt.Sprintf("event{id=%s type=%s time=%s payload=%s}", e.id, e.meta.Type, e.meta.Time, string(e.payload))
 }
 
-// EventHandler consume event
-type EventHandler func(e *Event /*, errReporter EventErrReporter*/)
+var ErrClosed = errors.New("event bus is closed")
+
+// EventHandler consume event, the event is retried or dead lettered if error is returned
+type EventHandler func(ctx context.Context, e *Event) error
 
 // Bus bus
 type EventBus interface {
 	// Publish event by specifying event type and payload
-	Publish(t string, payload interface{}) error
+	Publish(t string, payload interface{}, opts ...PublishOption) error
 
 	// Subscribe event handler
-	Subscribe(t string, name string, h EventHandler) error
+	Subscribe(t string, name string, h EventHandler, opts ...SubscribeOption) error
 
 	// Unsubscribe event handler
 	Unsubscribe(t string, name string, h EventHandler) error
+
+	// Use middlewares for all subscriptions, including the existing ones
+	Use(mws ...Middleware)
+
+	// Replay dead letters of subscriber through middlewares of bus, see Dispatcher.Replay
+	Replay(ctx context.Context, q DeadLetterQueue, subscriber string, h EventHandler, limit int, opts ...SubscribeOption) (int, error)
+
+	// Stop consuming, wait for events in progress to be handled and release resources.
+	// Handlers are canceled through their context if ctx is done before that.
+	Close(ctx context.Context) error
+}
+
+// Batch publisher, event buses implement it to publish events in one round trip
+type BatchPublisher interface {
+	// Publish events created by NewEvent, events are kept in order within the same type
+	PublishBatch(events ...*Event) error
 }
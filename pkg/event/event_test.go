@@ -12,3 +12,37 @@ func TestEventTime(t *testing.T) {
 		t.Error("event time error")
 	}
 }
+
+func TestEventKey(t *testing.T) {
+	e, _ := NewEvent("test", "hello", WithKey("order-1"))
+	if e.Meta().Key != "order-1" {
+		t.Errorf("key expected to be 'order-1' but got '%s'", e.Meta().Key)
+	}
+	e1, _ := LoadEvent(e.id.String(), e.meta.Time.UnixNano(), "test", `"hello"`, WithKey("order-1"))
+	if e1.Meta().Key != "order-1" {
+		t.Errorf("key expected to be loaded but got '%s'", e1.Meta().Key)
+	}
+}
+
+func TestEventShard(t *testing.T) {
+	e1, _ := NewEvent("test", 1, WithKey("order-1"))
+	e2, _ := NewEvent("test", 2, WithKey("order-1"))
+	if e1.Shard(8) != e2.Shard(8) {
+		t.Errorf("events with the same key expected in the same shard")
+	}
+	shards := map[int]bool{}
+	for i := 0; i < 100; i++ {
+		e, _ := NewEvent("test", i)
+		s := e.Shard(4)
+		if s < 0 || s >= 4 {
+			t.Fatalf("shard out of range: %d", s)
+		}
+		shards[s] = true
+	}
+	if len(shards) != 4 {
+		t.Errorf("events without key expected to be spread but got %v", shards)
+	}
+	if e1.Shard(1) != 0 || e1.Shard(0) != 0 {
+		t.Errorf("single shard expected to be 0")
+	}
+}
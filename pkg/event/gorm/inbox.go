@@ -0,0 +1,76 @@
+package gorm
+
+import (
+	"context"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/event"
+	"github.com/ofavor/ddd-go/pkg/log"
+	"github.com/ofavor/ddd-go/pkg/tx"
+	txgorm "github.com/ofavor/ddd-go/pkg/tx/gorm"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Inbox table model, a row is an event handled by a subscriber
+type InboxEntry struct {
+	Subscriber string    `gorm:"primaryKey;type:varchar(191)"`
+	EventId    string    `gorm:"primaryKey;type:varchar(36)"`
+	HandledAt  time.Time `gorm:"not null;index"`
+}
+
+func (e *InboxEntry) TableName() string {
+	return "event_inbox"
+}
+
+// gormInbox records handled events in the same transaction as the handler, so that
+// the event is recorded if and only if the changes of handler are committed
+type gormInbox struct {
+	conn *gorm.DB
+	tm   tx.TransMgr
+}
+
+// Create gorm inbox, the inbox table is migrated automatically
+func NewInbox(conn *gorm.DB) event.Inbox {
+	if err := conn.AutoMigrate(&InboxEntry{}); err != nil {
+		panic(err)
+	}
+	return NewInboxWithoutMigration(conn)
+}
+
+// Create gorm inbox, the inbox table should be registered by user
+func NewInboxWithoutMigration(conn *gorm.DB) event.Inbox {
+	return &gormInbox{
+		conn: conn,
+		tm:   txgorm.NewTransMgr(conn),
+	}
+}
+
+// Process implements event.Inbox. The handler runs in a transaction which is available by tx.FromContext,
+// changes made through it are committed with the record. Concurrent duplicates wait for the first one.
+func (i *gormInbox) Process(ctx context.Context, subscriber string, e *event.Event, h event.EventHandler) error {
+	return i.tm.Transaction(func(t tx.Trans) error {
+		conn := t.GetPrincipal().(*gorm.DB).WithContext(ctx)
+		r := conn.Clauses(clause.OnConflict{DoNothing: true}).Create(&InboxEntry{
+			Subscriber: subscriber,
+			EventId:    e.Id().String(),
+			HandledAt:  time.Now(),
+		})
+		if r.Error != nil {
+			log.Errorf("[event-gorm] Got error while recording event %s: %v", e, r.Error)
+			return r.Error
+		}
+		if r.RowsAffected == 0 {
+			log.Debugf("[event-gorm] %s skipped duplicate event %s", subscriber, e)
+			return nil
+		}
+		return h(tx.NewContext(ctx, t), e)
+	})
+}
+
+// Delete records of events handled before the given time, duplicates delivered later are handled again
+func Purge(ctx context.Context, conn *gorm.DB, before time.Time) (int64, error) {
+	r := conn.WithContext(ctx).Where("handled_at < ?", before).Delete(&InboxEntry{})
+	return r.RowsAffected, r.Error
+}
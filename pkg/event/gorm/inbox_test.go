@@ -0,0 +1,124 @@
+package gorm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/event"
+	"github.com/ofavor/ddd-go/pkg/tx"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type order struct {
+	Id string `gorm:"primaryKey"`
+}
+
+func newTestDB(t *testing.T) *gorm.DB {
+	conn, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, _ := conn.DB()
+	db.SetMaxOpenConns(1) // every connection has its own memory database
+	t.Cleanup(func() { db.Close() })
+	if err := conn.AutoMigrate(&order{}); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestInboxSkipsDuplicates(t *testing.T) {
+	conn := newTestDB(t)
+	inbox := NewInbox(conn)
+	e, _ := event.NewEvent("test", "hello")
+	calls := 0
+	h := func(ctx context.Context, e *event.Event) error {
+		calls++
+		conn := tx.FromContext(ctx).GetPrincipal().(*gorm.DB)
+		return conn.Create(&order{Id: e.Id().String()}).Error
+	}
+	for i := 0; i < 3; i++ {
+		if err := inbox.Process(context.Background(), "module", e, h); err != nil {
+			t.Errorf("no error expected but got '%v'", err)
+		}
+	}
+	if err := inbox.Process(context.Background(), "other", e, func(ctx context.Context, e *event.Event) error {
+		calls++
+		return nil
+	}); err != nil {
+		t.Errorf("no error expected but got '%v'", err)
+	}
+	if calls != 2 {
+		t.Errorf("event expected to be handled once by each subscriber but got %d calls", calls)
+	}
+	var n int64
+	conn.Model(&order{}).Count(&n)
+	if n != 1 {
+		t.Errorf("1 order expected but got %d", n)
+	}
+}
+
+func TestInboxRollback(t *testing.T) {
+	conn := newTestDB(t)
+	inbox := NewInbox(conn)
+	e, _ := event.NewEvent("test", "hello")
+	calls := 0
+	h := func(ctx context.Context, e *event.Event) error {
+		calls++
+		conn := tx.FromContext(ctx).GetPrincipal().(*gorm.DB)
+		if err := conn.Create(&order{Id: e.Id().String()}).Error; err != nil {
+			return err
+		}
+		if calls == 1 {
+			return errors.New("failed")
+		}
+		return nil
+	}
+	if err := inbox.Process(context.Background(), "module", e, h); err == nil {
+		t.Error("error expected for failed handler")
+	}
+	var n int64
+	conn.Model(&InboxEntry{}).Count(&n)
+	if n != 0 {
+		t.Errorf("failed event should not be recorded but got %d records", n)
+	}
+	if err := inbox.Process(context.Background(), "module", e, h); err != nil {
+		t.Errorf("no error expected but got '%v'", err)
+	}
+	conn.Model(&order{}).Count(&n)
+	if calls != 2 || n != 1 {
+		t.Errorf("event expected to be handled again and committed once but got %d calls and %d orders", calls, n)
+	}
+}
+
+func TestInboxSubscription(t *testing.T) {
+	conn := newTestDB(t)
+	e, _ := event.NewEvent("test", "hello")
+	calls := 0
+	h := func(ctx context.Context, e *event.Event) error {
+		calls++
+		return nil
+	}
+	o := event.NewSubscribeOptions(event.WithInbox(NewInbox(conn)))
+	event.Handle(context.Background(), e, "module", h, o)
+	event.Handle(context.Background(), e, "module", h, o)
+	if calls != 1 {
+		t.Errorf("duplicate delivery should be skipped but got %d calls", calls)
+	}
+
+	n, err := Purge(context.Background(), conn, time.Now().Add(time.Second))
+	if err != nil || n != 1 {
+		t.Errorf("1 record expected to be purged but got %d, %v", n, err)
+	}
+	event.Handle(context.Background(), e, "module", h, o)
+	if calls != 2 {
+		t.Errorf("event should be handled again after purge but got %d calls", calls)
+	}
+}
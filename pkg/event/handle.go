@@ -0,0 +1,173 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/log"
+)
+
+// Retry policy of handler, delays grow exponentially from InitialBackoff by Multiplier up to MaxBackoff
+type RetryPolicy struct {
+	// Max attempts including the first one, values less than 1 are treated as 1
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Backoff multiplier, 2 is used if it is less than 1
+	Multiplier float64
+}
+
+// Get delay before the retry following the given attempt, attempts start from 1
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	m := p.Multiplier
+	if m < 1 {
+		m = 2
+	}
+	d := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		d *= m
+		if p.MaxBackoff > 0 && d >= float64(p.MaxBackoff) {
+			return p.MaxBackoff
+		}
+	}
+	return time.Duration(d)
+}
+
+// Subscription options
+type SubscribeOptions struct {
+	Retry       RetryPolicy
+	DeadLetter  DeadLetterSink
+	Concurrency int
+	Middlewares []Middleware
+	Inbox       Inbox
+}
+
+// Subscription option
+type SubscribeOption func(o *SubscribeOptions)
+
+// Retry failed handling with policy, events are handled once by default
+func WithRetry(p RetryPolicy) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Retry = p
+	}
+}
+
+// Put events into dead letter sink when all attempts fail, they are logged and dropped by default
+func WithDeadLetter(s DeadLetterSink) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.DeadLetter = s
+	}
+}
+
+// Handle events with n workers, events with the same key are handled by the same worker in order.
+// Events are handled one by one by default. Kafka bus handles events in partition order and ignores it.
+func WithConcurrency(n int) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Concurrency = n
+	}
+}
+
+// Wrap handler with middlewares, they run inside the middlewares used on the bus
+func WithMiddleware(mws ...Middleware) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Middlewares = append(o.Middlewares, mws...)
+	}
+}
+
+// Create subscription options, it is used by event bus implementations
+func NewSubscribeOptions(opts ...SubscribeOption) *SubscribeOptions {
+	o := &SubscribeOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.Concurrency < 1 {
+		o.Concurrency = 1
+	}
+	return o
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Mark error as permanent, the event is not retried and goes to dead letter directly
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+// Check if error is permanent
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// Call handler with context of event upcasted to the current version, panic is recovered as error
+func call(ctx context.Context, e *Event, h EventHandler) error {
+	return Recovery()(func(ctx context.Context, e *Event) error {
+		e, err := Upcast(e)
+		if err != nil {
+			return Permanent(err)
+		}
+		return h(NewContext(ctx, e), e)
+	})(ctx, e)
+}
+
+// Handle event for subscriber with retries, the event is put into dead letter sink if all attempts fail.
+// The error of last attempt is returned, it is nil if the event is dead lettered successfully.
+// Middlewares of options are applied, each attempt goes through them.
+func Handle(ctx context.Context, e *Event, subscriber string, h EventHandler, o *SubscribeOptions) error {
+	return new(Dispatcher).Dispatch(ctx, e, subscriber, h, o)
+}
+
+func handle(ctx context.Context, e *Event, subscriber string, h EventHandler, o *SubscribeOptions) error {
+	attempts := o.Retry.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for i := 1; i <= attempts; i++ {
+		if err = call(ctx, e, h); err == nil {
+			return nil
+		}
+		log.Warnf("[event] %s failed to handle %s (attempt %d/%d): %v", subscriber, e, i, attempts, err)
+		if i == attempts || IsPermanent(err) {
+			attempts = i
+			break
+		}
+		t := time.NewTimer(o.Retry.Backoff(i))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+	if o.DeadLetter == nil {
+		log.Errorf("[event] %s dropped event %s: %v", subscriber, e, err)
+		return err
+	}
+	dl := &DeadLetter{
+		Event:      e,
+		Subscriber: subscriber,
+		Error:      err.Error(),
+		Attempts:   attempts,
+		Time:       time.Now(),
+	}
+	if perr := o.DeadLetter.Put(ctx, dl); perr != nil {
+		log.Errorf("[event] %s failed to dead letter event %s: %v", subscriber, e, perr)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type sliceQueue struct {
+	letters []*DeadLetter
+	lock    sync.Mutex
+}
+
+func (q *sliceQueue) Put(ctx context.Context, dl *DeadLetter) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	dl.Id = dl.Event.Id().String()
+	q.letters = append(q.letters, dl)
+	return nil
+}
+
+func (q *sliceQueue) List(ctx context.Context, limit int) ([]*DeadLetter, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return append([]*DeadLetter{}, q.letters...), nil
+}
+
+func (q *sliceQueue) Delete(ctx context.Context, id string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for i, dl := range q.letters {
+		if dl.Id == id {
+			q.letters = append(q.letters[:i], q.letters[i+1:]...)
+		}
+	}
+	return nil
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	expected := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond}
+	for i, d := range expected {
+		if b := p.Backoff(i + 1); b != d {
+			t.Errorf("backoff of attempt %d expected to be %v but got %v", i+1, d, b)
+		}
+	}
+}
+
+func TestHandleRetry(t *testing.T) {
+	e, _ := NewEvent("test", "hello")
+	calls := 0
+	h := func(ctx context.Context, e *Event) error {
+		calls++
+		if calls < 3 {
+			return errors.New("temporary")
+		}
+		return nil
+	}
+	o := NewSubscribeOptions(WithRetry(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}))
+	if err := Handle(context.Background(), e, "sub", h, o); err != nil {
+		t.Errorf("no error expected but got '%v'", err)
+	}
+	if calls != 3 {
+		t.Errorf("3 calls expected but got %d", calls)
+	}
+}
+
+func TestHandleDeadLetter(t *testing.T) {
+	e, _ := NewEvent("test", "hello")
+	q := &sliceQueue{}
+	calls := 0
+	h := func(ctx context.Context, e *Event) error {
+		calls++
+		panic("boom")
+	}
+	o := NewSubscribeOptions(WithRetry(RetryPolicy{MaxAttempts: 2}), WithDeadLetter(q))
+	if err := Handle(context.Background(), e, "sub", h, o); err != nil {
+		t.Errorf("no error expected once dead lettered but got '%v'", err)
+	}
+	if calls != 2 {
+		t.Errorf("2 calls expected but got %d", calls)
+	}
+	if len(q.letters) != 1 {
+		t.Fatalf("1 dead letter expected but got %d", len(q.letters))
+	}
+	dl := q.letters[0]
+	if dl.Subscriber != "sub" || dl.Attempts != 2 || dl.Error != "panic: boom" || dl.Event != e {
+		t.Errorf("unexpected dead letter %+v", dl)
+	}
+}
+
+func TestHandleWithoutDeadLetter(t *testing.T) {
+	e, _ := NewEvent("test", "hello")
+	h := func(ctx context.Context, e *Event) error {
+		return errors.New("failed")
+	}
+	if err := Handle(context.Background(), e, "sub", h, NewSubscribeOptions()); err == nil {
+		t.Errorf("error expected without dead letter sink")
+	}
+}
+
+func TestHandleCanceled(t *testing.T) {
+	e, _ := NewEvent("test", "hello")
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	h := func(ctx context.Context, e *Event) error {
+		calls++
+		cancel()
+		return errors.New("failed")
+	}
+	o := NewSubscribeOptions(WithRetry(RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour}))
+	if err := Handle(ctx, e, "sub", h, o); err == nil {
+		t.Errorf("error expected when canceled")
+	}
+	if calls != 1 {
+		t.Errorf("retries should stop when canceled but got %d calls", calls)
+	}
+}
+
+func TestDeadLetterJSON(t *testing.T) {
+	e, _ := NewEvent("test", map[string]int{"n": 1})
+	dl := &DeadLetter{Event: e, Subscriber: "sub", Error: "failed", Attempts: 3, Time: time.Now()}
+	j, err := dl.MarshalJSON()
+	if err != nil {
+		t.Fatalf("no error expected but got '%v'", err)
+	}
+	dl1 := &DeadLetter{}
+	if err := dl1.UnmarshalJSON(j); err != nil {
+		t.Fatalf("no error expected but got '%v'", err)
+	}
+	if dl1.Event.Id() != e.Id() || string(dl1.Event.Payload()) != `{"n":1}` || dl1.Attempts != 3 || !dl1.Time.Equal(dl.Time) {
+		t.Errorf("dead letter expected to be restored but got %+v", dl1)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	q := &sliceQueue{}
+	for _, sub := range []string{"a", "a", "b"} {
+		e, _ := NewEvent("test", sub)
+		q.Put(context.Background(), &DeadLetter{Event: e, Subscriber: sub})
+	}
+	replayed := 0
+	h := func(ctx context.Context, e *Event) error {
+		replayed++
+		return nil
+	}
+	n, err := Replay(context.Background(), q, "a", h, 0)
+	if err != nil || n != 2 || replayed != 2 {
+		t.Errorf("2 dead letters expected to be replayed but got %d, %v", n, err)
+	}
+	if len(q.letters) != 1 || q.letters[0].Subscriber != "b" {
+		t.Errorf("dead letter of other subscriber should be kept")
+	}
+	failing := func(ctx context.Context, e *Event) error {
+		return errors.New("failed")
+	}
+	if n, err := Replay(context.Background(), q, "b", failing, 0); err == nil || n != 0 {
+		t.Errorf("error expected when replay fails but got %d, %v", n, err)
+	}
+	if len(q.letters) != 1 {
+		t.Errorf("failed dead letter should be kept")
+	}
+}
@@ -0,0 +1,177 @@
+package event
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// Well-known headers
+const (
+	// Id of the first event of a flow, it is shared by all events caused by it
+	HeaderCorrelationId = "correlation-id"
+	// Id of the event being handled when this one is published
+	HeaderCausationId = "causation-id"
+	// Service publishing the event
+	HeaderSource = "source"
+	HeaderTenant = "tenant"
+	// Version of payload schema, 0 if it is absent
+	HeaderSchemaVersion = "schema-version"
+	// W3C trace context
+	HeaderTraceparent = "traceparent"
+	HeaderTracestate  = "tracestate"
+)
+
+// Headers propagated from the handled event to the events published by its handler,
+// source is not since it is the service publishing the event
+var propagatedHeaders = []string{HeaderCorrelationId, HeaderTenant, HeaderTraceparent, HeaderTracestate}
+
+// Event headers
+type Headers map[string]string
+
+// Copy headers
+func (h Headers) Clone() Headers {
+	c := make(Headers, len(h))
+	for k, v := range h {
+		c[k] = v
+	}
+	return c
+}
+
+// Get header value, empty if it is absent
+func (m Meta) Header(name string) string {
+	return m.Headers[name]
+}
+
+// Get correlation id
+func (m Meta) CorrelationId() string {
+	return m.Headers[HeaderCorrelationId]
+}
+
+// Get causation id, empty if the event is not published by a handler
+func (m Meta) CausationId() string {
+	return m.Headers[HeaderCausationId]
+}
+
+// Get source service
+func (m Meta) Source() string {
+	return m.Headers[HeaderSource]
+}
+
+// Get tenant
+func (m Meta) Tenant() string {
+	return m.Headers[HeaderTenant]
+}
+
+// Get payload schema version, 0 if it is absent or invalid
+func (m Meta) SchemaVersion() int {
+	v, _ := strconv.Atoi(m.Headers[HeaderSchemaVersion])
+	return v
+}
+
+// Get W3C traceparent
+func (m Meta) Traceparent() string {
+	return m.Headers[HeaderTraceparent]
+}
+
+// Get W3C tracestate
+func (m Meta) Tracestate() string {
+	return m.Headers[HeaderTracestate]
+}
+
+// Set header
+func WithHeader(name, value string) PublishOption {
+	return func(o *PublishOptions) {
+		if o.Headers == nil {
+			o.Headers = Headers{}
+		}
+		o.Headers[name] = value
+	}
+}
+
+// Set headers, they are used as is while loading events
+func WithHeaders(h Headers) PublishOption {
+	return func(o *PublishOptions) {
+		if o.Headers == nil {
+			o.Headers = Headers{}
+		}
+		for k, v := range h {
+			o.Headers[k] = v
+		}
+	}
+}
+
+// Set payload schema version
+func WithSchemaVersion(v int) PublishOption {
+	return WithHeader(HeaderSchemaVersion, strconv.Itoa(v))
+}
+
+// Propagate headers from context, see ContextWithHeaders. Within a handler the event being handled
+// becomes the cause, and its correlation id is shared. Explicit headers take precedence.
+func WithContext(ctx context.Context) PublishOption {
+	return func(o *PublishOptions) {
+		o.Context = ctx
+	}
+}
+
+// Build headers of a new event, schema version is the current one of event type unless it is set
+func (o *PublishOptions) headers(id uuid.UUID, t string) Headers {
+	h := Headers{}
+	if o.Context != nil {
+		for k, v := range HeadersFromContext(o.Context) {
+			h[k] = v
+		}
+		if cause := FromContext(o.Context); cause != nil {
+			h[HeaderCausationId] = cause.Id().String()
+		}
+	}
+	for k, v := range o.Headers {
+		h[k] = v
+	}
+	if h[HeaderCorrelationId] == "" {
+		h[HeaderCorrelationId] = id.String()
+	}
+	if _, ok := h[HeaderSchemaVersion]; !ok {
+		if v := CurrentVersion(t); v > 0 {
+			h[HeaderSchemaVersion] = strconv.Itoa(v)
+		}
+	}
+	return h
+}
+
+type headersKey struct{}
+
+type eventKey struct{}
+
+// Add headers to context, they are merged with the existing ones
+func ContextWithHeaders(ctx context.Context, h Headers) context.Context {
+	merged := HeadersFromContext(ctx).Clone()
+	for k, v := range h {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, headersKey{}, merged)
+}
+
+// Get headers from context
+func HeadersFromContext(ctx context.Context) Headers {
+	h, _ := ctx.Value(headersKey{}).(Headers)
+	return h
+}
+
+// Create handler context of event, propagated headers of the event are added to it
+func NewContext(ctx context.Context, e *Event) context.Context {
+	h := Headers{}
+	for _, k := range propagatedHeaders {
+		if v := e.meta.Headers[k]; v != "" {
+			h[k] = v
+		}
+	}
+	return context.WithValue(ContextWithHeaders(ctx, h), eventKey{}, e)
+}
+
+// Get the event being handled from context
+func FromContext(ctx context.Context) *Event {
+	e, _ := ctx.Value(eventKey{}).(*Event)
+	return e
+}
@@ -0,0 +1,78 @@
+package event
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDefaultHeaders(t *testing.T) {
+	e, _ := NewEvent("test", "hello")
+	if e.Meta().CorrelationId() != e.Id().String() {
+		t.Errorf("correlation id expected to default to event id but got '%s'", e.Meta().CorrelationId())
+	}
+	if e.Meta().CausationId() != "" || e.Meta().SchemaVersion() != 0 {
+		t.Errorf("no causation id and schema version expected but got %v", e.Meta().Headers)
+	}
+}
+
+func TestExplicitHeaders(t *testing.T) {
+	e, _ := NewEvent("test", "hello",
+		WithHeader(HeaderSource, "order-service"),
+		WithHeader("custom", "value"),
+		WithSchemaVersion(2),
+	)
+	m := e.Meta()
+	if m.Source() != "order-service" || m.Header("custom") != "value" || m.SchemaVersion() != 2 {
+		t.Errorf("unexpected headers %v", m.Headers)
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	ctx := ContextWithHeaders(context.Background(), Headers{
+		HeaderTenant:      "t1",
+		HeaderSource:      "order-service",
+		HeaderTraceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	})
+	first, _ := NewEvent("created", "hello", WithContext(ctx))
+	if first.Meta().Tenant() != "t1" || first.Meta().Source() != "order-service" || first.Meta().Traceparent() == "" {
+		t.Errorf("headers expected to be taken from context but got %v", first.Meta().Headers)
+	}
+
+	var second *Event
+	h := func(ctx context.Context, e *Event) error {
+		if FromContext(ctx) != e {
+			t.Errorf("handled event expected in context")
+		}
+		// another service handles the event
+		ctx = ContextWithHeaders(ctx, Headers{HeaderSource: "billing-service"})
+		second, _ = NewEvent("billed", "hello", WithContext(ctx), WithHeader(HeaderTenant, "t2"))
+		return nil
+	}
+	if err := Handle(context.Background(), first, "sub", h, NewSubscribeOptions()); err != nil {
+		t.Fatalf("no error expected but got '%v'", err)
+	}
+	m := second.Meta()
+	if m.CorrelationId() != first.Id().String() || m.CausationId() != first.Id().String() {
+		t.Errorf("correlation and causation expected to be the first event but got %v", m.Headers)
+	}
+	if m.Traceparent() != first.Meta().Traceparent() || m.Source() != "billing-service" {
+		t.Errorf("trace expected to be propagated and source not but got %v", m.Headers)
+	}
+	if m.Tenant() != "t2" {
+		t.Errorf("explicit header expected to take precedence but got %v", m.Headers)
+	}
+}
+
+func TestDeadLetterHeaders(t *testing.T) {
+	e, _ := NewEvent("test", "hello", WithKey("k"), WithHeader(HeaderTenant, "t1"))
+	dl := &DeadLetter{Event: e, Time: time.Now()}
+	j, _ := dl.MarshalJSON()
+	dl1 := &DeadLetter{}
+	if err := dl1.UnmarshalJSON(j); err != nil {
+		t.Fatalf("no error expected but got '%v'", err)
+	}
+	if dl1.Event.Meta().Tenant() != "t1" || dl1.Event.Meta().Key != "k" {
+		t.Errorf("headers and key expected to be restored but got %+v", dl1.Event.Meta())
+	}
+}
@@ -0,0 +1,65 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/cache"
+	"github.com/ofavor/ddd-go/pkg/log"
+)
+
+// Inbox records events handled by subscribers, so that duplicate deliveries are skipped
+type Inbox interface {
+	// Handle event with h unless it is recorded for subscriber, the event is recorded once h succeeds
+	Process(ctx context.Context, subscriber string, e *Event, h EventHandler) error
+}
+
+// Skip events already handled by the subscriber according to inbox, it runs inside all middlewares
+func WithInbox(i Inbox) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Inbox = i
+	}
+}
+
+func inboxMiddleware(i Inbox) Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, e *Event) error {
+			return i.Process(ctx, SubscriberFromContext(ctx), e, next)
+		}
+	}
+}
+
+// Inbox based on cache, handled events are recorded for ttl
+type cacheInbox struct {
+	cache cache.Cache
+	ttl   time.Duration
+}
+
+// Create inbox recording handled events in cache for ttl. The event is recorded after it is handled,
+// so duplicates handled concurrently are not detected and a failed record leads to handling it again.
+func NewCacheInbox(c cache.Cache, ttl time.Duration) Inbox {
+	return &cacheInbox{cache: c, ttl: ttl}
+}
+
+// Process implements Inbox.
+func (i *cacheInbox) Process(ctx context.Context, subscriber string, e *Event, h EventHandler) error {
+	key := fmt.Sprintf("__handled__:%s:%s", subscriber, e.Id())
+	var handled bool
+	err := i.cache.Get(ctx, key, &handled)
+	if err == nil && handled {
+		log.Debugf("[event] %s skipped duplicate event %s", subscriber, e)
+		return nil
+	}
+	if err != nil && !errors.Is(err, cache.ErrNil) {
+		log.Warnf("[event] Got error while checking duplicate event %s: %v", e, err)
+	}
+	if err := h(ctx, e); err != nil {
+		return err
+	}
+	if err := i.cache.Set(ctx, key, true, i.ttl); err != nil {
+		log.Warnf("[event] Got error while recording event %s: %v", e, err)
+	}
+	return nil
+}
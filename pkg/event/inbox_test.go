@@ -0,0 +1,25 @@
+package event
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/cache/memory"
+)
+
+func TestWithInbox(t *testing.T) {
+	e, _ := NewEvent("test", "hello")
+	calls := []string{}
+	h := func(ctx context.Context, e *Event) error {
+		calls = append(calls, "handler")
+		return nil
+	}
+	o := NewSubscribeOptions(WithInbox(NewCacheInbox(memory.NewCache(100), time.Minute)), WithMiddleware(tag(&calls, "sub")))
+	Handle(context.Background(), e, "module", h, o)
+	Handle(context.Background(), e, "module", h, o)
+	if strings.Join(calls, ",") != "sub,handler,sub" {
+		t.Error("inbox should run inside middlewares and skip duplicates:", calls)
+	}
+}
@@ -0,0 +1,30 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/event"
+
+	"go.uber.org/goleak"
+)
+
+func TestClose(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+	// nothing listens on the port, consumers fail to prepare topics
+	bus := NewEventBus([]string{"127.0.0.1:1"}, 10, "test")
+	h := func(ctx context.Context, e *event.Event) error {
+		return nil
+	}
+	bus.Subscribe("test", "test", h)
+	bus.Subscribe("test", "test1", h)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := bus.Close(ctx); err != nil {
+		t.Errorf("no error expected for Close but got '%v'", err)
+	}
+	if err := bus.Publish("test", "late"); err != event.ErrClosed {
+		t.Errorf("ErrClosed expected after Close but got '%v'", err)
+	}
+}
@@ -0,0 +1,210 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/event"
+	"github.com/ofavor/ddd-go/pkg/log"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Kafka dead letter sink interface
+type DeadLetterSink interface {
+	event.DeadLetterSink
+
+	// Flush pending writes and release the writer
+	Close() error
+}
+
+// Kafka dead letter queue interface
+type DeadLetterQueue interface {
+	event.DeadLetterQueue
+
+	// Release the reader and writer
+	Close() error
+}
+
+// Kafka dead letter sink, dead letters are written to a topic and acknowledged by all in-sync replicas.
+// Id is not assigned since the writer does not report offsets.
+type deadLetterSink struct {
+	writer *kafka.Writer
+}
+
+// NewDeadLetterSink create kafka dead letter sink writing to topic, use NewDeadLetterQueue to inspect them
+func NewDeadLetterSink(brokers []string, topic string) DeadLetterSink {
+	return &deadLetterSink{
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(brokers...),
+			Topic:                  topic,
+			RequiredAcks:           kafka.RequireAll,
+			AllowAutoTopicCreation: true,
+		},
+	}
+}
+
+// Put implements event.DeadLetterSink.
+func (s *deadLetterSink) Put(ctx context.Context, dl *event.DeadLetter) error {
+	j, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	if err := s.writer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(dl.Event.Id().String()),
+		Value: j,
+	}); err != nil {
+		log.Warnf("[event-kafka] Got error while putting dead letter: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Close implements DeadLetterSink.
+func (s *deadLetterSink) Close() error {
+	return s.writer.Close()
+}
+
+// Reader of dead letter topic, kafka.Reader satisfies it
+type messageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// Dead letter fetched from topic
+type fetchedLetter struct {
+	dl      *event.DeadLetter
+	msg     kafka.Message
+	deleted bool
+}
+
+// Kafka dead letter queue, dead letters are read by a consumer group and deleted by committing offsets.
+// Id of a dead letter is "partition:offset", it is assigned when the dead letter is listed.
+// Offsets of a partition are committed up to the first dead letter kept, so deleted ones after it are
+// hidden from List but read again by the next queue of the group.
+type deadLetterQueue struct {
+	*deadLetterSink
+	reader messageReader
+	// time to wait for more dead letters while listing
+	wait time.Duration
+	// dead letters fetched and not committed, in fetching order
+	fetched []*fetchedLetter
+	lock    sync.Mutex
+}
+
+// Default time to wait for more dead letters while listing
+const listWait = time.Second
+
+// NewDeadLetterQueue create kafka dead letter queue on topic, group tracks the deleted dead letters
+func NewDeadLetterQueue(brokers []string, topic, group string) DeadLetterQueue {
+	return newDeadLetterQueue(NewDeadLetterSink(brokers, topic).(*deadLetterSink), kafka.NewReader(kafka.ReaderConfig{
+		Brokers: brokers,
+		Topic:   topic,
+		GroupID: group,
+	}))
+}
+
+func newDeadLetterQueue(sink *deadLetterSink, reader messageReader) *deadLetterQueue {
+	return &deadLetterQueue{
+		deadLetterSink: sink,
+		reader:         reader,
+		wait:           listWait,
+	}
+}
+
+// List implements event.DeadLetterQueue, it waits for 1s at most for more dead letters.
+func (q *deadLetterQueue) List(ctx context.Context, limit int) ([]*event.DeadLetter, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	dls := []*event.DeadLetter{}
+	for _, f := range q.fetched {
+		if limit > 0 && len(dls) >= limit {
+			return dls, nil
+		}
+		if !f.deleted {
+			dls = append(dls, f.dl)
+		}
+	}
+	for limit <= 0 || len(dls) < limit {
+		fctx, cancel := context.WithTimeout(ctx, q.wait)
+		m, err := q.reader.FetchMessage(fctx)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if fctx.Err() == nil {
+				log.Warnf("[event-kafka] Got error while fetching dead letter: %v", err)
+			}
+			break
+		}
+		f := &fetchedLetter{dl: &event.DeadLetter{}, msg: m}
+		q.fetched = append(q.fetched, f)
+		if err := json.Unmarshal(m.Value, f.dl); err != nil {
+			// it can never be replayed
+			log.Warnf("[event-kafka] Got error while loading dead letter at %d:%d: %v", m.Partition, m.Offset, err)
+			f.deleted = true
+			continue
+		}
+		f.dl.Id = fmt.Sprintf("%d:%d", m.Partition, m.Offset)
+		dls = append(dls, f.dl)
+	}
+	return dls, q.commit(ctx)
+}
+
+// Delete implements event.DeadLetterQueue, only listed dead letters can be deleted.
+func (q *deadLetterQueue) Delete(ctx context.Context, id string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, f := range q.fetched {
+		if f.dl.Id == id {
+			f.deleted = true
+		}
+	}
+	return q.commit(ctx)
+}
+
+// Commit offsets of deleted dead letters not preceded by a kept one in the same partition.
+// Must be called with lock held.
+func (q *deadLetterQueue) commit(ctx context.Context) error {
+	kept := make([]*fetchedLetter, 0, len(q.fetched))
+	blocked := map[int]bool{}
+	last := map[int]kafka.Message{}
+	for _, f := range q.fetched {
+		p := f.msg.Partition
+		if f.deleted && !blocked[p] {
+			last[p] = f.msg
+			continue
+		}
+		if !f.deleted {
+			blocked[p] = true
+		}
+		kept = append(kept, f)
+	}
+	if len(last) == 0 {
+		return nil
+	}
+	msgs := make([]kafka.Message, 0, len(last))
+	for _, m := range last {
+		msgs = append(msgs, m)
+	}
+	if err := q.reader.CommitMessages(ctx, msgs...); err != nil {
+		log.Warnf("[event-kafka] Got error while committing dead letters: %v", err)
+		return err
+	}
+	q.fetched = kept
+	return nil
+}
+
+// Close implements DeadLetterQueue.
+func (q *deadLetterQueue) Close() error {
+	err := q.reader.Close()
+	if werr := q.deadLetterSink.Close(); err == nil {
+		err = werr
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/event"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type fakeReader struct {
+	messages  []kafka.Message
+	next      int
+	committed map[int]int64
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	if r.next < len(r.messages) {
+		r.next++
+		return r.messages[r.next-1], nil
+	}
+	<-ctx.Done()
+	return kafka.Message{}, ctx.Err()
+}
+
+func (r *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	for _, m := range msgs {
+		r.committed[m.Partition] = m.Offset
+	}
+	return nil
+}
+
+func (r *fakeReader) Close() error {
+	return nil
+}
+
+func TestDeadLetterQueue(t *testing.T) {
+	r := &fakeReader{committed: map[int]int64{}}
+	for i, p := range []int{0, 0, 1, 0} {
+		e, _ := event.NewEvent("test", i)
+		j, _ := json.Marshal(&event.DeadLetter{Event: e, Subscriber: "module", Attempts: 1, Time: time.Now()})
+		r.messages = append(r.messages, kafka.Message{Partition: p, Offset: int64(i), Value: j})
+	}
+	r.messages = append(r.messages, kafka.Message{Partition: 1, Offset: 4, Value: []byte("invalid")})
+	q := newDeadLetterQueue(NewDeadLetterSink([]string{"127.0.0.1:1"}, "dead").(*deadLetterSink), r)
+	q.wait = 10 * time.Millisecond
+	defer q.Close()
+
+	dls, err := q.List(context.Background(), 0)
+	if err != nil || len(dls) != 4 || dls[0].Id != "0:0" || dls[2].Id != "1:2" {
+		t.Fatalf("4 dead letters expected but got %v, %v", dls, err)
+	}
+	if again, _ := q.List(context.Background(), 2); len(again) != 2 || again[0].Id != "0:0" {
+		t.Errorf("listed dead letters expected to be listed again but got %v", again)
+	}
+
+	failing := map[string]bool{dls[1].Event.Id().String(): true}
+	h := func(ctx context.Context, e *event.Event) error {
+		if failing[e.Id().String()] {
+			return errors.New("failed")
+		}
+		return nil
+	}
+	n, err := event.Replay(context.Background(), q, "module", h, 0)
+	if err == nil || n != 3 {
+		t.Errorf("3 dead letters expected to be replayed but got %d, %v", n, err)
+	}
+	if r.committed[0] != 0 || r.committed[1] != 4 {
+		t.Errorf("offsets expected to be committed up to the kept dead letter but got %v", r.committed)
+	}
+	if dls, _ := q.List(context.Background(), 0); len(dls) != 1 || dls[0].Id != "0:1" {
+		t.Errorf("only the failed dead letter expected to be kept but got %v", dls)
+	}
+}
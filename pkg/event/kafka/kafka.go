@@ -4,8 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"reflect"
-	"runtime/debug"
 	"strconv"
 	"sync"
 	"time"
@@ -16,43 +14,12 @@ import (
 	"github.com/segmentio/kafka-go"
 )
 
-type eventHandlerWrapper struct {
-	bus        *kafkaEventBus
-	moduleName string
-	callback   reflect.Value
-	fn         event.EventHandler
-	events     chan *event.Event
-}
-
-func (h *eventHandlerWrapper) start() {
-	for e := range h.events {
-		h.handle(e)
-	}
-}
-
-func (h *eventHandlerWrapper) handle(e *event.Event) {
-	defer func() {
-		if err := recover(); err != nil {
-			log.Error("[event-kafka] Got error while handling event:", e)
-			log.Error(err, string(debug.Stack()))
-		}
-	}()
-	h.fn(e)
-}
-
-func (h *eventHandlerWrapper) stop() {
-	if h.events != nil {
-		close(h.events)
-	}
-}
-
-const consumerBufferSize int64 = 1000
-
 type eventConsumer struct {
 	bus       *kafkaEventBus
 	eventType string
-	handlers  []*eventHandlerWrapper
+	handlers  []*event.Subscription
 	cancel    context.CancelFunc
+	done      chan struct{}
 }
 
 func (c *eventConsumer) prepareTopic() error {
@@ -76,55 +43,77 @@ func (c *eventConsumer) prepareTopic() error {
 	return err
 }
 
-func (c *eventConsumer) start() {
+func (c *eventConsumer) start(ctx context.Context) {
+	defer close(c.done)
+	if err := c.prepareTopic(); err != nil {
+		log.Warnf("[event-kafka] Got error while preparing topic: %v", err)
+		return
+	}
 	reader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers: c.bus.brokers,
 		Topic:   c.bus.genTopicKey(c.eventType),
 		GroupID: c.bus.group,
 	})
 	defer reader.Close()
-	ctx, cancel := context.WithCancel(context.Background())
-	c.cancel = cancel
-
-	if err := c.prepareTopic(); err != nil {
-		log.Warnf("[event-kafka] Got error while preparing topic: %v", err)
-		// time.Sleep(time.Second * 10)
-		// continue
-		return
-	}
 
 	for {
-		m, err := reader.ReadMessage(ctx)
+		m, err := reader.FetchMessage(ctx)
 		if err != nil {
-			if err.Error() == "fetching message: context canceled" { // context is canceled
+			if ctx.Err() != nil { // context is canceled
 				log.Info("[event-kafka] Context canceled")
 				break
 			}
 			log.Warnf("[event-kafka] Got error while reading message: %v", err)
-			time.Sleep(time.Second * 10)
-			continue
-		}
-		val := map[string]interface{}{}
-		err = json.Unmarshal(m.Value, &val)
-		if err != nil {
-			log.Warnf("[event-kafka] Got error while unmarshalling message: %v", err)
-			continue
-		}
-		id := val["id"].(string)
-		tm, _ := strconv.ParseInt(val["time"].(string), 10, 64)
-		tp := val["type"].(string)
-		pl := val["payload"].(string)
-		e, err := event.LoadEvent(id, tm, tp, pl)
-		if err != nil {
-			log.Warnf("[event-kafka] Got error while loading event: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second * 10):
+			}
 			continue
 		}
-		for _, h := range c.handlers {
-			h.events <- e
+		c.process(m)
+		// the offset is committed even if the consumer is stopping, since the message is handled
+		if err := reader.CommitMessages(context.WithoutCancel(ctx), m); err != nil {
+			log.Warnf("[event-kafka] Got error while committing message: %v", err)
 		}
 	}
 }
 
+// Dispatch message to handlers and wait for them. Failed events are dead lettered or dropped by event.Handle,
+// since the offset of partition can not skip a single message.
+func (c *eventConsumer) process(m kafka.Message) {
+	e, err := loadEvent(m)
+	if err != nil {
+		log.Warnf("[event-kafka] Got error while loading event: %v", err)
+		return
+	}
+	c.bus.lock.RLock()
+	handlers := append([]*event.Subscription{}, c.handlers...)
+	c.bus.lock.RUnlock()
+	results := make([]<-chan error, len(handlers))
+	for i, h := range handlers {
+		results[i] = h.Deliver(e)
+	}
+	for _, result := range results {
+		<-result
+	}
+}
+
+func loadEvent(m kafka.Message) (*event.Event, error) {
+	val := message{}
+	if err := json.Unmarshal(m.Value, &val); err != nil {
+		return nil, err
+	}
+	tm, err := strconv.ParseInt(val.Time, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	headers := event.Headers{}
+	for _, h := range m.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+	return event.LoadEvent(val.Id, tm, val.Type, string(val.Payload), event.WithKey(val.Key), event.WithHeaders(headers))
+}
+
 func (c *eventConsumer) stop() {
 	if c.cancel != nil {
 		log.Debug("[event-kafka] Cancel event consumer: ", c.eventType)
@@ -132,75 +121,203 @@ func (c *eventConsumer) stop() {
 	}
 }
 
+// Message value of event, payload is embedded as raw JSON
+type message struct {
+	Id      string          `json:"id"`
+	Time    string          `json:"time"`
+	Type    string          `json:"type"`
+	Key     string          `json:"key,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Kafka event bus option
+type Option func(b *kafkaEventBus)
+
+// Set max number of messages in a batch and the time to wait for a batch to fill up, defaults are 100 and 1s.
+// Synchronous publish waits for the batch to be written, so linger should be short unless async is enabled.
+func WithBatch(size int, linger time.Duration) Option {
+	return func(b *kafkaEventBus) {
+		b.writer.BatchSize = size
+		b.writer.BatchTimeout = linger
+	}
+}
+
+// Set acks required from brokers, kafka.RequireAll by default like the writer config of kafka-go
+func WithRequiredAcks(acks kafka.RequiredAcks) Option {
+	return func(b *kafkaEventBus) {
+		b.writer.RequiredAcks = acks
+	}
+}
+
+// Publish asynchronously, Publish returns once events are queued and delivery errors are reported to the handler.
+// Errors before queueing, e.g. failed metadata lookup, are still returned. Queued events are flushed on Close.
+func WithAsync(h ErrorHandler) Option {
+	return func(b *kafkaEventBus) {
+		b.writer.Async = true
+		b.onError = h
+	}
+}
+
+// Delivery error handler of async publishing, it is called for each event failed to deliver
+type ErrorHandler func(e *event.Event, err error)
+
 type kafkaEventBus struct {
+	event.Dispatcher
 	brokers    []string
 	bufferSize int64
 	group      string
+	writer     *kafka.Writer
+	onError    ErrorHandler
 	consumers  map[string]*eventConsumer
 	lock       *sync.RWMutex
+	closed     bool
+	lifecycle  *event.Lifecycle
 }
 
-func NewEventBus(brokers []string, bufferSize int64, group string) event.EventBus {
+func NewEventBus(brokers []string, bufferSize int64, group string, opts ...Option) event.EventBus {
 	bus := &kafkaEventBus{
 		brokers:    brokers,
 		bufferSize: bufferSize,
 		group:      group,
-		consumers:  map[string]*eventConsumer{},
-		lock:       new(sync.RWMutex),
+		// topic is set per message, so that a single writer serves all event types.
+		// Messages with the same key go to the same partition, which keeps them in order.
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(brokers...),
+			Balancer:               &kafka.Hash{},
+			RequiredAcks:           kafka.RequireAll,
+			AllowAutoTopicCreation: true,
+		},
+		consumers: map[string]*eventConsumer{},
+		lock:      new(sync.RWMutex),
+		lifecycle: event.NewLifecycle(),
 	}
+	for _, opt := range opts {
+		opt(bus)
+	}
+	bus.writer.Completion = bus.complete
 	return bus
 }
 
+// Report delivery errors of async publishing, errors of sync publishing are returned by Publish
+func (b *kafkaEventBus) complete(msgs []kafka.Message, err error) {
+	if err == nil || !b.writer.Async {
+		return
+	}
+	log.Warnf("[event-kafka] Got error while delivering %d events: %v", len(msgs), err)
+	if b.onError == nil {
+		return
+	}
+	for _, m := range msgs {
+		e, lerr := loadEvent(m)
+		if lerr != nil {
+			log.Warnf("[event-kafka] Got error while loading event: %v", lerr)
+			continue
+		}
+		b.onError(e, err)
+	}
+}
+
 func (b *kafkaEventBus) genTopicKey(t string) string {
 	return fmt.Sprintf("__event__.%s", t)
 }
 
 // Publish implements event.EventBus.
-func (b *kafkaEventBus) Publish(t string, payload interface{}) error {
-	e, _ := event.NewEvent(t, payload)
+func (b *kafkaEventBus) Publish(t string, payload interface{}, opts ...event.PublishOption) error {
+	e, _ := event.NewEvent(t, payload, opts...)
 	log.Debug("[event-kafka] Publish event: ", e)
-	writer := kafka.NewWriter(kafka.WriterConfig{
-		Brokers: b.brokers,
-		Topic:   b.genTopicKey(t),
-	})
-	ev, err := json.Marshal(map[string]interface{}{
-		"id":      e.Id().String(),
-		"time":    fmt.Sprintf("%d", e.Meta().Time.UnixNano()),
-		"type":    e.Meta().Type,
-		"payload": e.Payload(),
-	})
+	b.lock.RLock()
+	closed := b.closed
+	b.lock.RUnlock()
+	if closed {
+		return event.ErrClosed
+	}
+	m, err := b.message(e)
 	if err != nil {
 		return err
 	}
-	if err := writer.WriteMessages(context.Background(), kafka.Message{
-		Key:   []byte(e.Id().String()),
-		Value: ev,
-	}); err != nil {
+	if err := b.writer.WriteMessages(context.Background(), m); err != nil {
 		log.Warnf("[event-kafka] Got error while publishing event: %v", err)
 		return err
 	}
 	return nil
 }
 
+func (b *kafkaEventBus) message(e *event.Event) (kafka.Message, error) {
+	ev, err := json.Marshal(message{
+		Id:      e.Id().String(),
+		Time:    fmt.Sprintf("%d", e.Meta().Time.UnixNano()),
+		Type:    e.Meta().Type,
+		Key:     e.Meta().Key,
+		Payload: e.Payload(),
+	})
+	if err != nil {
+		return kafka.Message{}, err
+	}
+	// events without key are spread by id
+	key := e.Meta().Key
+	if key == "" {
+		key = e.Id().String()
+	}
+	headers := make([]kafka.Header, 0, len(e.Meta().Headers))
+	for k, v := range e.Meta().Headers {
+		headers = append(headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+	return kafka.Message{
+		Topic:   b.genTopicKey(e.Meta().Type),
+		Key:     []byte(key),
+		Value:   ev,
+		Headers: headers,
+	}, nil
+}
+
+// PublishBatch implements event.BatchPublisher, events are written in batches.
+func (b *kafkaEventBus) PublishBatch(events ...*event.Event) error {
+	b.lock.RLock()
+	closed := b.closed
+	b.lock.RUnlock()
+	if closed {
+		return event.ErrClosed
+	}
+	msgs := make([]kafka.Message, 0, len(events))
+	for _, e := range events {
+		m, err := b.message(e)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, m)
+	}
+	if err := b.writer.WriteMessages(context.Background(), msgs...); err != nil {
+		log.Warnf("[event-kafka] Got error while publishing events: %v", err)
+		return err
+	}
+	return nil
+}
+
 // Subscribe implements event.EventBus.
-func (b *kafkaEventBus) Subscribe(t string, name string, h event.EventHandler) error {
+func (b *kafkaEventBus) Subscribe(t string, name string, h event.EventHandler, opts ...event.SubscribeOption) error {
 	log.Debug("[event-kafka] Subscribe event: ", t)
 	b.lock.Lock()
 	defer b.lock.Unlock()
+	if b.closed {
+		return event.ErrClosed
+	}
 	c, ok := b.consumers[t]
 	if !ok {
 		log.Debugf("[event-kafka] Create and start consumer for event: %s", t)
 		c = &eventConsumer{
 			bus:       b,
 			eventType: t,
-			handlers:  []*eventHandlerWrapper{},
+			handlers:  []*event.Subscription{},
+			done:      make(chan struct{}),
 		}
 		b.consumers[t] = c
-		go c.start()
+		ctx, cancel := context.WithCancel(context.Background())
+		c.cancel = cancel
+		go c.start(ctx)
 	}
-	eh := &eventHandlerWrapper{bus: b, moduleName: name, callback: reflect.ValueOf(h), fn: h, events: make(chan *event.Event, consumerBufferSize)}
-	go eh.start()
-	c.handlers = append(c.handlers, eh)
+	s := b.NewSubscription(t, name, h, opts...)
+	b.lifecycle.Start(s)
+	c.handlers = append(c.handlers, s)
 	return nil
 }
 
@@ -209,13 +326,12 @@ func (b *kafkaEventBus) Unsubscribe(t string, name string, h event.EventHandler)
 	log.Debug("[event-kafka] Unsubscribe event: ", t)
 	b.lock.Lock()
 	defer b.lock.Unlock()
-	rh := reflect.ValueOf(h)
 	c, ok := b.consumers[t]
 	if ok {
 		for i, k := range c.handlers {
-			if k.moduleName == name && k.callback.Pointer() == rh.Pointer() {
+			if k.Is(name, h) {
 				c.handlers = append(c.handlers[:i], c.handlers[i+1:]...)
-				k.stop()
+				k.Stop()
 				break
 			}
 		}
@@ -227,3 +343,38 @@ func (b *kafkaEventBus) Unsubscribe(t string, name string, h event.EventHandler)
 	}
 	return nil
 }
+
+// Close implements event.EventBus, the events in progress are handled and committed before it returns.
+func (b *kafkaEventBus) Close(ctx context.Context) error {
+	log.Debug("[event-kafka] Close event bus")
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		return nil
+	}
+	b.closed = true
+	consumers := b.consumers
+	b.consumers = map[string]*eventConsumer{}
+	b.lock.Unlock()
+
+	for _, c := range consumers {
+		c.stop()
+	}
+	err := b.lifecycle.Close(ctx, func() {
+		for _, c := range consumers {
+			<-c.done
+		}
+	}, func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		for _, c := range consumers {
+			for _, h := range c.handlers {
+				h.Stop()
+			}
+		}
+	})
+	if werr := b.writer.Close(); err == nil {
+		err = werr
+	}
+	return err
+}
@@ -0,0 +1,126 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/event"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestMessage(t *testing.T) {
+	bus := NewEventBus([]string{"127.0.0.1:1"}, 10, "test").(*kafkaEventBus)
+	defer bus.Close(context.Background())
+	e, _ := event.NewEvent("test", map[string]int{"n": 1})
+	m, err := bus.message(e)
+	if err != nil {
+		t.Fatalf("no error expected but got '%v'", err)
+	}
+	if m.Topic != "__event__.test" {
+		t.Errorf("topic of event type expected but got '%s'", m.Topic)
+	}
+	e1, err := loadEvent(m)
+	if err != nil {
+		t.Fatalf("no error expected but got '%v'", err)
+	}
+	if e1.Id() != e.Id() || string(e1.Payload()) != `{"n":1}` || e1.Meta().Time.UnixNano() != e.Meta().Time.UnixNano() {
+		t.Errorf("event expected to be restored but got %s", e1)
+	}
+}
+
+func TestMessageKey(t *testing.T) {
+	bus := NewEventBus([]string{"127.0.0.1:1"}, 10, "test").(*kafkaEventBus)
+	defer bus.Close(context.Background())
+	e, _ := event.NewEvent("test", 1, event.WithKey("order-1"))
+	m, _ := bus.message(e)
+	if string(m.Key) != "order-1" {
+		t.Errorf("message key expected to be the ordering key but got '%s'", m.Key)
+	}
+	if e1, _ := loadEvent(m); e1.Meta().Key != "order-1" {
+		t.Errorf("key expected to be restored but got '%s'", e1.Meta().Key)
+	}
+	if _, ok := bus.writer.Balancer.(*kafka.Hash); !ok {
+		t.Errorf("hash balancer expected")
+	}
+}
+
+func TestMessageHeaders(t *testing.T) {
+	bus := NewEventBus([]string{"127.0.0.1:1"}, 10, "test").(*kafkaEventBus)
+	defer bus.Close(context.Background())
+	e, _ := event.NewEvent("test", 1, event.WithHeader(event.HeaderTenant, "t1"))
+	m, _ := bus.message(e)
+	found := false
+	for _, h := range m.Headers {
+		if h.Key == event.HeaderTenant && string(h.Value) == "t1" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("tenant expected in kafka headers but got %v", m.Headers)
+	}
+	e1, _ := loadEvent(m)
+	if e1.Meta().Tenant() != "t1" || e1.Meta().CorrelationId() != e.Id().String() {
+		t.Errorf("headers expected to be restored but got %v", e1.Meta().Headers)
+	}
+	if _, ok := bus.writer.Balancer.(*kafka.Hash); !ok {
+		t.Errorf("hash balancer expected")
+	}
+}
+
+func TestOptions(t *testing.T) {
+	bus := NewEventBus([]string{"127.0.0.1:1"}, 10, "test",
+		WithBatch(50, 10*time.Millisecond),
+		WithRequiredAcks(kafka.RequireOne),
+	).(*kafkaEventBus)
+	defer bus.Close(context.Background())
+	w := bus.writer
+	if w.BatchSize != 50 || w.BatchTimeout != 10*time.Millisecond || w.RequiredAcks != kafka.RequireOne || w.Async {
+		t.Errorf("unexpected writer config %+v", w)
+	}
+
+	bus = NewEventBus([]string{"127.0.0.1:1"}, 10, "test").(*kafkaEventBus)
+	defer bus.Close(context.Background())
+	if bus.writer.RequiredAcks != kafka.RequireAll {
+		t.Errorf("all acks expected to be required by default but got %v", bus.writer.RequiredAcks)
+	}
+}
+
+func TestAsyncDeliveryError(t *testing.T) {
+	failed := make(chan *event.Event, 10)
+	bus := NewEventBus([]string{"127.0.0.1:1"}, 10, "test",
+		WithAsync(func(e *event.Event, err error) {
+			failed <- e
+		}),
+	).(*kafkaEventBus)
+	defer bus.Close(context.Background())
+	if !bus.writer.Async || bus.writer.Completion == nil {
+		t.Fatalf("async writer with completion expected")
+	}
+
+	e1, _ := event.NewEvent("test", 1)
+	e2, _ := event.NewEvent("test", 2)
+	m1, _ := bus.message(e1)
+	m2, _ := bus.message(e2)
+	// no broker is available, the completion of a failed batch is simulated
+	bus.writer.Completion([]kafka.Message{m1, m2}, errors.New("broker unavailable"))
+	close(failed)
+	ids := map[string]bool{}
+	for e := range failed {
+		ids[e.Id().String()] = true
+	}
+	if len(ids) != 2 || !ids[e1.Id().String()] || !ids[e2.Id().String()] {
+		t.Errorf("both events expected to be reported but got %v", ids)
+	}
+}
+
+func TestSyncDeliveryError(t *testing.T) {
+	bus := NewEventBus([]string{"127.0.0.1:1"}, 10, "test", WithBatch(1, time.Millisecond)).(*kafkaEventBus)
+	bus.writer.MaxAttempts = 1
+	defer bus.Close(context.Background())
+	if err := bus.Publish("test", "hello"); err == nil {
+		t.Errorf("error expected when broker is unavailable")
+	}
+}
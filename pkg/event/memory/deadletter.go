@@ -0,0 +1,54 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ofavor/ddd-go/pkg/event"
+
+	"github.com/google/uuid"
+)
+
+// Memory dead letter queue, dead letters are lost when the process exits
+type deadLetterQueue struct {
+	letters []*event.DeadLetter
+	lock    sync.Mutex
+}
+
+// NewDeadLetterQueue create memory dead letter queue
+func NewDeadLetterQueue() event.DeadLetterQueue {
+	return &deadLetterQueue{}
+}
+
+// Put implements event.DeadLetterSink.
+func (q *deadLetterQueue) Put(ctx context.Context, dl *event.DeadLetter) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	dl.Id = uuid.NewString()
+	q.letters = append(q.letters, dl)
+	return nil
+}
+
+// List implements event.DeadLetterQueue.
+func (q *deadLetterQueue) List(ctx context.Context, limit int) ([]*event.DeadLetter, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	n := len(q.letters)
+	if limit > 0 && limit < n {
+		n = limit
+	}
+	return append([]*event.DeadLetter{}, q.letters[:n]...), nil
+}
+
+// Delete implements event.DeadLetterQueue.
+func (q *deadLetterQueue) Delete(ctx context.Context, id string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for i, dl := range q.letters {
+		if dl.Id == id {
+			q.letters = append(q.letters[:i], q.letters[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
@@ -1,8 +1,7 @@
 package memory
 
 import (
-	"reflect"
-	"runtime/debug"
+	"context"
 	"sync"
 
 	"github.com/ofavor/ddd-go/pkg/event"
@@ -10,58 +9,33 @@ import (
 )
 
 // Memory event bus implementation.
-type eventHandlerWrapper struct {
-	bus        *memoryEventBus
-	moduleName string
-	callback   reflect.Value
-	fn         event.EventHandler
-	events     chan *event.Event
-}
-
-func (h *eventHandlerWrapper) start() {
-	go func() {
-		for e := range h.events {
-			h.handle(e)
-		}
-	}()
-}
-
-func (h *eventHandlerWrapper) handle(e *event.Event) {
-	defer func() {
-		if err := recover(); err != nil {
-			log.Error("[event-mem] Got error while handling event:", e)
-			log.Error(err, string(debug.Stack()))
-		}
-	}()
-	h.fn(e)
-}
-
-func (h *eventHandlerWrapper) stop() {
-	if h.events != nil {
-		close(h.events)
-	}
-}
-
 type memoryEventBus struct {
-	handlers map[string][]*eventHandlerWrapper
+	event.Dispatcher
+	handlers map[string][]*event.Subscription
 	lock     *sync.RWMutex
 	events   chan *event.Event
+	// closed is guarded by closeLock, publishers hold it while sending to events
+	closed    bool
+	closeLock sync.RWMutex
+	done      chan struct{}
+	lifecycle *event.Lifecycle
 }
 
-const consumerBufferSize = 100
-
 // NewEventBus create memory event bus
 func NewEventBus(bufferSize int64) event.EventBus {
 	bus := &memoryEventBus{
-		handlers: map[string][]*eventHandlerWrapper{},
-		lock:     new(sync.RWMutex),
-		events:   make(chan *event.Event, bufferSize),
+		handlers:  map[string][]*event.Subscription{},
+		lock:      new(sync.RWMutex),
+		events:    make(chan *event.Event, bufferSize),
+		done:      make(chan struct{}),
+		lifecycle: event.NewLifecycle(),
 	}
 	go bus.run() // start main goroutine
 	return bus
 }
 
 func (b *memoryEventBus) run() {
+	defer close(b.done)
 	for e := range b.events {
 		log.Debug("[event-mem] Bus got event:", e)
 		b.handleEvent(e)
@@ -74,29 +48,47 @@ func (b *memoryEventBus) handleEvent(e *event.Event) {
 	hh, ok := b.handlers[e.Meta().Type]
 	if ok {
 		for _, k := range hh {
-			k.events <- e
+			k.Deliver(e)
 		}
 	}
 }
 
-func (b *memoryEventBus) Publish(t string, payload interface{}) error {
-	e, _ := event.NewEvent(t, payload)
+func (b *memoryEventBus) Publish(t string, payload interface{}, opts ...event.PublishOption) error {
+	e, _ := event.NewEvent(t, payload, opts...)
+	b.closeLock.RLock()
+	defer b.closeLock.RUnlock()
+	if b.closed {
+		return event.ErrClosed
+	}
 	b.events <- e
 	return nil
 }
 
-func (b *memoryEventBus) Subscribe(t string, name string, h event.EventHandler) error {
+// PublishBatch implements event.BatchPublisher.
+func (b *memoryEventBus) PublishBatch(events ...*event.Event) error {
+	b.closeLock.RLock()
+	defer b.closeLock.RUnlock()
+	if b.closed {
+		return event.ErrClosed
+	}
+	for _, e := range events {
+		b.events <- e
+	}
+	return nil
+}
+
+func (b *memoryEventBus) Subscribe(t string, name string, h event.EventHandler, opts ...event.SubscribeOption) error {
 	log.Debug("[event-mem] Subscribe event: ", t)
+	b.closeLock.RLock()
+	defer b.closeLock.RUnlock()
+	if b.closed {
+		return event.ErrClosed
+	}
 	b.lock.Lock()
 	defer b.lock.Unlock()
-	hh, ok := b.handlers[t]
-	if !ok {
-		hh = []*eventHandlerWrapper{}
-	}
-	eh := &eventHandlerWrapper{bus: b, moduleName: name, callback: reflect.ValueOf(h), fn: h, events: make(chan *event.Event, consumerBufferSize)}
-	eh.start()
-	hh = append(hh, eh)
-	b.handlers[t] = hh
+	s := b.NewSubscription(t, name, h, opts...)
+	b.lifecycle.Start(s)
+	b.handlers[t] = append(b.handlers[t], s)
 	return nil
 }
 
@@ -104,19 +96,45 @@ func (b *memoryEventBus) Unsubscribe(t string, name string, h event.EventHandler
 	log.Debug("[event-mem] Unsubscribe event: ", t)
 	b.lock.Lock()
 	defer b.lock.Unlock()
-	c := reflect.ValueOf(h)
 	hh, ok := b.handlers[t]
 	if ok {
 		for i, k := range hh {
-			if k.moduleName == name && k.callback.Pointer() == c.Pointer() {
+			if k.Is(name, h) {
 				hh = append(hh[:i], hh[i+1:]...)
-				k.stop()
+				k.Stop()
 				break
 			}
 		}
 		if len(hh) == 0 {
 			delete(b.handlers, t)
+		} else {
+			b.handlers[t] = hh
 		}
 	}
 	return nil
 }
+
+// Close implements event.EventBus, published events are dispatched and handled before it returns.
+// If ctx is done first, the events left are dropped.
+func (b *memoryEventBus) Close(ctx context.Context) error {
+	log.Debug("[event-mem] Close event bus")
+	b.closeLock.Lock()
+	if b.closed {
+		b.closeLock.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.events)
+	b.closeLock.Unlock()
+
+	return b.lifecycle.Close(ctx, func() { <-b.done }, func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		for _, hh := range b.handlers {
+			for _, h := range hh {
+				h.Stop()
+			}
+		}
+		b.handlers = map[string][]*event.Subscription{}
+	})
+}
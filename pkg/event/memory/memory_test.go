@@ -0,0 +1,205 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/event"
+
+	"go.uber.org/goleak"
+)
+
+func TestRetryAndDeadLetter(t *testing.T) {
+	bus := NewEventBus(10)
+	defer bus.Close(context.Background())
+	q := NewDeadLetterQueue()
+	calls := make(chan struct{}, 10)
+	h := func(ctx context.Context, e *event.Event) error {
+		calls <- struct{}{}
+		return errors.New("failed")
+	}
+	bus.Subscribe("test", "test", h, event.WithRetry(event.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}), event.WithDeadLetter(q))
+	bus.Publish("test", "hello")
+
+	deadline := time.Now().Add(time.Second)
+	var dls []*event.DeadLetter
+	for len(dls) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		dls, _ = q.List(context.Background(), 0)
+	}
+	if len(dls) != 1 || dls[0].Attempts != 3 || dls[0].Subscriber != "test" {
+		t.Fatalf("1 dead letter after 3 attempts expected but got %v", dls)
+	}
+	if len(calls) != 3 {
+		t.Errorf("3 calls expected but got %d", len(calls))
+	}
+
+	n, err := event.Replay(context.Background(), q, "test", func(ctx context.Context, e *event.Event) error {
+		return nil
+	}, 0)
+	if err != nil || n != 1 {
+		t.Errorf("1 dead letter expected to be replayed but got %d, %v", n, err)
+	}
+	if dls, _ := q.List(context.Background(), 0); len(dls) != 0 {
+		t.Errorf("replayed dead letter should be deleted")
+	}
+}
+
+func TestCloseDrains(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+	bus := NewEventBus(10)
+	var handled int32
+	bus.Subscribe("test", "test", func(ctx context.Context, e *event.Event) error {
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&handled, 1)
+		return nil
+	})
+	for i := 0; i < 20; i++ {
+		bus.Publish("test", i)
+	}
+	if err := bus.Close(context.Background()); err != nil {
+		t.Errorf("no error expected for Close but got '%v'", err)
+	}
+	if n := atomic.LoadInt32(&handled); n != 20 {
+		t.Errorf("20 events expected to be handled before Close returns but got %d", n)
+	}
+	if err := bus.Publish("test", "late"); err != event.ErrClosed {
+		t.Errorf("ErrClosed expected after Close but got '%v'", err)
+	}
+	if err := bus.Close(context.Background()); err != nil {
+		t.Errorf("no error expected for closing twice but got '%v'", err)
+	}
+}
+
+func TestCloseTimeout(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+	bus := NewEventBus(10)
+	canceled := make(chan struct{})
+	bus.Subscribe("test", "test", func(ctx context.Context, e *event.Event) error {
+		<-ctx.Done()
+		close(canceled)
+		return ctx.Err()
+	})
+	bus.Publish("test", "hello")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := bus.Close(ctx); err != context.DeadlineExceeded {
+		t.Errorf("DeadlineExceeded expected but got '%v'", err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Errorf("handler expected to be canceled when Close times out")
+	}
+}
+
+func TestCloseTimeoutStopsWorkers(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+	bus := NewEventBus(10)
+	bus.Subscribe("test", "test", func(ctx context.Context, e *event.Event) error {
+		<-ctx.Done()
+		return nil
+	})
+	// more events than buffered by the worker, so that dispatching is still blocked when Close times out
+	for i := 0; i < 105; i++ {
+		bus.Publish("test", i)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := bus.Close(ctx); err != context.DeadlineExceeded {
+		t.Errorf("DeadlineExceeded expected but got '%v'", err)
+	}
+}
+
+func TestPublishBatch(t *testing.T) {
+	bus := NewEventBus(10)
+	var handled int32
+	bus.Subscribe("test", "test", func(ctx context.Context, e *event.Event) error {
+		atomic.AddInt32(&handled, 1)
+		return nil
+	})
+	e1, _ := event.NewEvent("test", 1)
+	e2, _ := event.NewEvent("test", 2)
+	if err := bus.(event.BatchPublisher).PublishBatch(e1, e2); err != nil {
+		t.Errorf("no error expected for PublishBatch but got '%v'", err)
+	}
+	bus.Close(context.Background())
+	if n := atomic.LoadInt32(&handled); n != 2 {
+		t.Errorf("2 events expected to be handled but got %d", n)
+	}
+}
+
+func TestOrderingByKey(t *testing.T) {
+	bus := NewEventBus(100)
+	var lock sync.Mutex
+	seen := map[string][]int{}
+	var running, maxRunning int32
+	bus.Subscribe("test", "test", func(ctx context.Context, e *event.Event) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		var seq int
+		json.Unmarshal(e.Payload(), &seq)
+		lock.Lock()
+		seen[e.Meta().Key] = append(seen[e.Meta().Key], seq)
+		lock.Unlock()
+		return nil
+	}, event.WithConcurrency(4))
+	for i := 0; i < 20; i++ {
+		for _, k := range []string{"a", "b", "c", "d"} {
+			bus.Publish("test", i, event.WithKey(k))
+		}
+	}
+	bus.Close(context.Background())
+	for k, seqs := range seen {
+		if len(seqs) != 20 {
+			t.Errorf("20 events expected for key %s but got %d", k, len(seqs))
+		}
+		for i, seq := range seqs {
+			if seq != i {
+				t.Errorf("events of key %s expected in order but got %v", k, seqs)
+				break
+			}
+		}
+	}
+	if atomic.LoadInt32(&maxRunning) < 2 {
+		t.Errorf("events of different keys expected to be handled in parallel")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	bus := NewEventBus(10)
+	var calls []string
+	var lock sync.Mutex
+	tag := func(name string) event.Middleware {
+		return func(next event.EventHandler) event.EventHandler {
+			return func(ctx context.Context, e *event.Event) error {
+				lock.Lock()
+				calls = append(calls, name)
+				lock.Unlock()
+				return next(ctx, e)
+			}
+		}
+	}
+	h := func(ctx context.Context, e *event.Event) error {
+		return nil
+	}
+	bus.Subscribe("test", "test", h, event.WithMiddleware(tag("sub")))
+	bus.Use(tag("bus"))
+	bus.Publish("test", "hello")
+	bus.Close(context.Background())
+	if len(calls) != 2 || calls[0] != "bus" || calls[1] != "sub" {
+		t.Errorf("bus middleware expected to wrap subscription middleware but got %v", calls)
+	}
+}
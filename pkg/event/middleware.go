@@ -0,0 +1,136 @@
+package event
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/cache"
+	"github.com/ofavor/ddd-go/pkg/log"
+	"github.com/ofavor/ddd-go/pkg/metrics"
+)
+
+// Handler middleware, it wraps handler to add behavior around handling
+type Middleware func(next EventHandler) EventHandler
+
+// Chain middlewares around handler, the first one is the outermost
+func Chain(h EventHandler, mws ...Middleware) EventHandler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// Recover panics as errors. Dispatcher always recovers handlers, it is useful to let the outer middlewares
+// see panics as errors.
+func Recovery() Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, e *Event) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Error(r, string(debug.Stack()))
+					err = fmt.Errorf("panic: %v", r)
+				}
+			}()
+			return next(ctx, e)
+		}
+	}
+}
+
+// Log handling with elapsed time at debug level, failures are logged by dispatcher as well
+func Logging() Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, e *Event) error {
+			subscriber := SubscriberFromContext(ctx)
+			log.Debugf("[event] %s handling %s", subscriber, e)
+			start := time.Now()
+			err := next(ctx, e)
+			if err != nil {
+				log.Debugf("[event] %s failed to handle %s in %s: %v", subscriber, e, time.Since(start), err)
+			} else {
+				log.Debugf("[event] %s handled %s in %s", subscriber, e, time.Since(start))
+			}
+			return err
+		}
+	}
+}
+
+// Cancel context of handler after d, handlers must respect the context to be interrupted
+func Timeout(d time.Duration) Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, e *Event) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			return next(ctx, e)
+		}
+	}
+}
+
+// Record handling metrics labeled by event type and subscriber:
+// event_handled_total (result: ok/error) and event_handle_seconds
+func Metrics(m metrics.Metrics) Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, e *Event) error {
+			subscriber := SubscriberFromContext(ctx)
+			start := time.Now()
+			err := next(ctx, e)
+			metrics.ObserveSince(m, "event_handle_seconds", metrics.Labels{"type": e.Meta().Type, "subscriber": subscriber}, start)
+			result := "ok"
+			if err != nil {
+				result = "error"
+			}
+			m.IncCounter("event_handled_total", metrics.Labels{"type": e.Meta().Type, "subscriber": subscriber, "result": result}, 1)
+			return err
+		}
+	}
+}
+
+// Start span of handling event, the returned context carries the span and end is called with the result
+type SpanStarter func(ctx context.Context, e *Event) (spanCtx context.Context, end func(err error))
+
+// Trace handling. Spans are started by start, e.g. with a tracer extracting the parent from Meta().Traceparent().
+// If start is nil, a child span id is generated and set as traceparent of context, so that events published
+// by the handler are children of the handling span.
+func Tracing(start SpanStarter) Middleware {
+	if start == nil {
+		start = startChildSpan
+	}
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, e *Event) error {
+			ctx, end := start(ctx, e)
+			err := next(ctx, e)
+			end(err)
+			return err
+		}
+	}
+}
+
+func startChildSpan(ctx context.Context, e *Event) (context.Context, func(error)) {
+	return ContextWithHeaders(ctx, Headers{HeaderTraceparent: childTraceparent(e.Meta().Traceparent())}), func(error) {}
+}
+
+// Create W3C traceparent of a child span, a new trace is started if parent is invalid
+func childTraceparent(parent string) string {
+	traceId, flags := "", "01"
+	if p := strings.Split(parent, "-"); len(p) == 4 && len(p[1]) == 32 && len(p[3]) == 2 {
+		traceId, flags = p[1], p[3]
+	} else {
+		traceId = randomHex(16)
+	}
+	return fmt.Sprintf("00-%s-%s-%s", traceId, randomHex(8), flags)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Skip events already handled by the subscriber, handled events are recorded in cache for ttl, see NewCacheInbox
+func Deduplicate(c cache.Cache, ttl time.Duration) Middleware {
+	return inboxMiddleware(NewCacheInbox(c, ttl))
+}
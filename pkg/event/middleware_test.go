@@ -0,0 +1,198 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/cache/memory"
+	"github.com/ofavor/ddd-go/pkg/metrics"
+)
+
+func tag(calls *[]string, name string) Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, e *Event) error {
+			*calls = append(*calls, name)
+			return next(ctx, e)
+		}
+	}
+}
+
+func TestDispatchMiddlewares(t *testing.T) {
+	e, _ := NewEvent("test", "hello")
+	calls := []string{}
+	d := &Dispatcher{}
+	d.Use(tag(&calls, "bus"))
+	o := NewSubscribeOptions(WithMiddleware(tag(&calls, "sub1"), tag(&calls, "sub2")))
+	subscriber := ""
+	h := func(ctx context.Context, e *Event) error {
+		calls = append(calls, "handler")
+		subscriber = SubscriberFromContext(ctx)
+		return nil
+	}
+	if err := d.Dispatch(context.Background(), e, "module", h, o); err != nil {
+		t.Error("dispatch should succeed:", err)
+	}
+	if strings.Join(calls, ",") != "bus,sub1,sub2,handler" {
+		t.Error("middlewares are called in wrong order:", calls)
+	}
+	if subscriber != "module" {
+		t.Error("subscriber should be in context:", subscriber)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	e, _ := NewEvent("test", "hello")
+	var seen error
+	observe := func(next EventHandler) EventHandler {
+		return func(ctx context.Context, e *Event) error {
+			seen = next(ctx, e)
+			return seen
+		}
+	}
+	h := func(ctx context.Context, e *Event) error {
+		panic("boom")
+	}
+	err := Handle(context.Background(), e, "module", h, NewSubscribeOptions(WithMiddleware(observe, Recovery())))
+	if err == nil || seen == nil {
+		t.Error("panic should be seen as error by outer middlewares")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	e, _ := NewEvent("test", "hello")
+	h := func(ctx context.Context, e *Event) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	err := Handle(context.Background(), e, "module", h, NewSubscribeOptions(WithMiddleware(Timeout(10*time.Millisecond))))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("handler should time out:", err)
+	}
+}
+
+type recorder struct {
+	counters map[string]float64
+	observed int
+	lock     sync.Mutex
+}
+
+func (r *recorder) IncCounter(name string, labels metrics.Labels, delta float64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.counters[name+":"+labels["subscriber"]+":"+labels["result"]] += delta
+}
+
+func (r *recorder) ObserveHistogram(name string, labels metrics.Labels, value float64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.observed++
+}
+
+func TestMetrics(t *testing.T) {
+	e, _ := NewEvent("test", "hello")
+	r := &recorder{counters: map[string]float64{}}
+	calls := 0
+	h := func(ctx context.Context, e *Event) error {
+		calls++
+		if calls == 1 {
+			return errors.New("temporary")
+		}
+		return nil
+	}
+	o := NewSubscribeOptions(WithRetry(RetryPolicy{MaxAttempts: 2}), WithMiddleware(Metrics(r)))
+	if err := Handle(context.Background(), e, "module", h, o); err != nil {
+		t.Error("handle should succeed:", err)
+	}
+	if r.counters["event_handled_total:module:error"] != 1 || r.counters["event_handled_total:module:ok"] != 1 {
+		t.Error("each attempt should be counted:", r.counters)
+	}
+	if r.observed != 2 {
+		t.Error("latency should be observed for each attempt:", r.observed)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	e, _ := NewEvent("test", "hello", WithHeader(HeaderTraceparent, parent))
+	var published *Event
+	h := func(ctx context.Context, e *Event) error {
+		published, _ = NewEvent("next", "world", WithContext(ctx))
+		return nil
+	}
+	Handle(context.Background(), e, "module", h, NewSubscribeOptions(WithMiddleware(Tracing(nil))))
+	tp := published.Meta().Traceparent()
+	if !strings.HasPrefix(tp, "00-4bf92f3577b34da6a3ce929d0e0e4736-") || !strings.HasSuffix(tp, "-01") || tp == parent {
+		t.Error("published event should be child of handling span:", tp)
+	}
+
+	e, _ = NewEvent("test", "hello")
+	Handle(context.Background(), e, "module", h, NewSubscribeOptions(WithMiddleware(Tracing(nil))))
+	if len(strings.Split(published.Meta().Traceparent(), "-")) != 4 {
+		t.Error("trace should be started if event has no traceparent:", published.Meta().Traceparent())
+	}
+
+	ended := error(nil)
+	start := func(ctx context.Context, e *Event) (context.Context, func(error)) {
+		return ctx, func(err error) { ended = err }
+	}
+	fail := func(ctx context.Context, e *Event) error {
+		return errors.New("failed")
+	}
+	Handle(context.Background(), e, "module", fail, NewSubscribeOptions(WithMiddleware(Tracing(start))))
+	if ended == nil {
+		t.Error("span should be ended with the result")
+	}
+}
+
+func TestDeduplicate(t *testing.T) {
+	c := memory.NewCache(100)
+	e, _ := NewEvent("test", "hello")
+	calls := map[string]int{}
+	h := func(ctx context.Context, e *Event) error {
+		calls[SubscriberFromContext(ctx)]++
+		if calls[SubscriberFromContext(ctx)] == 1 && SubscriberFromContext(ctx) == "failing" {
+			return errors.New("temporary")
+		}
+		return nil
+	}
+	o := NewSubscribeOptions(WithMiddleware(Deduplicate(c, time.Minute)))
+	for i := 0; i < 3; i++ {
+		Handle(context.Background(), e, "module", h, o)
+		Handle(context.Background(), e, "other", h, o)
+		Handle(context.Background(), e, "failing", h, o)
+	}
+	if calls["module"] != 1 || calls["other"] != 1 {
+		t.Error("event should be handled once by each subscriber:", calls)
+	}
+	if calls["failing"] != 2 {
+		t.Error("failed event should be handled again:", calls)
+	}
+}
+
+func TestReplayMiddlewares(t *testing.T) {
+	q := &sliceQueue{}
+	e, _ := NewEvent("test", "hello")
+	q.Put(context.Background(), &DeadLetter{Event: e, Subscriber: "module"})
+	calls := []string{}
+	d := &Dispatcher{}
+	d.Use(tag(&calls, "bus"))
+	h := func(ctx context.Context, e *Event) error {
+		calls = append(calls, SubscriberFromContext(ctx))
+		return errors.New("failed")
+	}
+	dl := &sliceQueue{}
+	n, err := d.Replay(context.Background(), q, "module", h, 0, WithMiddleware(tag(&calls, "sub")), WithDeadLetter(dl))
+	if err == nil || n != 0 {
+		t.Errorf("error expected when replay fails but got %d, %v", n, err)
+	}
+	if strings.Join(calls, ",") != "bus,sub,module" {
+		t.Error("replay should go through middlewares of bus and subscription:", calls)
+	}
+	if len(q.letters) != 1 || len(dl.letters) != 0 {
+		t.Error("dead letter failed again should be kept in queue instead of dead lettered anew")
+	}
+}
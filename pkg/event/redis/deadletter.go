@@ -0,0 +1,79 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ofavor/ddd-go/pkg/event"
+	"github.com/ofavor/ddd-go/pkg/log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis dead letter queue, dead letters are kept in a stream and Id is the stream entry id
+type deadLetterQueue struct {
+	conn   redis.UniversalClient
+	stream string
+	maxLen int64
+}
+
+// NewDeadLetterQueue create redis dead letter queue, the stream is trimmed to maxLen if it is positive
+func NewDeadLetterQueue(conn redis.UniversalClient, name string, maxLen int64) event.DeadLetterQueue {
+	return &deadLetterQueue{
+		conn:   conn,
+		stream: fmt.Sprintf("__dead__:{%s}", name),
+		maxLen: maxLen,
+	}
+}
+
+// Put implements event.DeadLetterSink.
+func (q *deadLetterQueue) Put(ctx context.Context, dl *event.DeadLetter) error {
+	j, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	id, err := q.conn.XAdd(ctx, &redis.XAddArgs{
+		Stream: q.stream,
+		MaxLen: q.maxLen,
+		Approx: q.maxLen > 0,
+		Values: map[string]interface{}{"letter": string(j)},
+	}).Result()
+	if err != nil {
+		log.Warnf("[event-redis] Got error while putting dead letter: %v", err)
+		return err
+	}
+	dl.Id = id
+	return nil
+}
+
+// List implements event.DeadLetterQueue.
+func (q *deadLetterQueue) List(ctx context.Context, limit int) ([]*event.DeadLetter, error) {
+	var msgs []redis.XMessage
+	var err error
+	if limit > 0 {
+		msgs, err = q.conn.XRangeN(ctx, q.stream, "-", "+", int64(limit)).Result()
+	} else {
+		msgs, err = q.conn.XRange(ctx, q.stream, "-", "+").Result()
+	}
+	if err != nil {
+		return nil, err
+	}
+	dls := make([]*event.DeadLetter, 0, len(msgs))
+	for _, msg := range msgs {
+		s, _ := msg.Values["letter"].(string)
+		dl := &event.DeadLetter{}
+		if err := json.Unmarshal([]byte(s), dl); err != nil {
+			log.Warnf("[event-redis] Got error while loading dead letter %s: %v", msg.ID, err)
+			continue
+		}
+		dl.Id = msg.ID
+		dls = append(dls, dl)
+	}
+	return dls, nil
+}
+
+// Delete implements event.DeadLetterQueue.
+func (q *deadLetterQueue) Delete(ctx context.Context, id string) error {
+	return q.conn.XDel(ctx, q.stream, id).Err()
+}
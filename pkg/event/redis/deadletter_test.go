@@ -0,0 +1,40 @@
+package redis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ofavor/ddd-go/pkg/event"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestDeadLetterQueue(t *testing.T) {
+	s := miniredis.RunT(t)
+	conn := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	t.Cleanup(func() { conn.Close() })
+	q := NewDeadLetterQueue(conn, "test", 100)
+	ctx := context.Background()
+
+	for _, p := range []string{"p1", "p2"} {
+		e, _ := event.NewEvent("test", p)
+		dl := &event.DeadLetter{Event: e, Subscriber: "sub", Error: "failed", Attempts: 3}
+		if err := q.Put(ctx, dl); err != nil {
+			t.Fatalf("no error expected for Put but got '%v'", err)
+		}
+		if dl.Id == "" {
+			t.Errorf("Id expected to be assigned")
+		}
+	}
+	dls, err := q.List(ctx, 1)
+	if err != nil || len(dls) != 1 || string(dls[0].Event.Payload()) != `"p1"` {
+		t.Fatalf("oldest dead letter expected but got %v, %v", dls, err)
+	}
+	if err := q.Delete(ctx, dls[0].Id); err != nil {
+		t.Errorf("no error expected for Delete but got '%v'", err)
+	}
+	dls, _ = q.List(ctx, 0)
+	if len(dls) != 1 || string(dls[0].Event.Payload()) != `"p2"` || dls[0].Attempts != 3 {
+		t.Errorf("remaining dead letter expected but got %v", dls)
+	}
+}
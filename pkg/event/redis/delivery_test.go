@@ -0,0 +1,305 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ofavor/ddd-go/pkg/event"
+	"github.com/ofavor/ddd-go/pkg/event/memory"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/goleak"
+)
+
+func newMiniredisBus(t *testing.T, opts ...Option) (*redisEventBus, *miniredis.Miniredis, redis.UniversalClient) {
+	s := miniredis.RunT(t)
+	conn := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	t.Cleanup(func() { conn.Close() })
+	bus := NewEventBusWithConn(conn, 100, "test", opts...).(*redisEventBus)
+	t.Cleanup(func() { bus.Close(context.Background()) })
+	return bus, s, conn
+}
+
+func pendingCount(t *testing.T, conn redis.UniversalClient, stream string) int64 {
+	p, err := conn.XPending(context.Background(), stream, "test").Result()
+	if err != nil {
+		t.Fatalf("no error expected for XPENDING but got '%v'", err)
+	}
+	return p.Count
+}
+
+func waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestAckAfterHandling(t *testing.T) {
+	bus, _, conn := newMiniredisBus(t)
+	var handled int32
+	release := make(chan struct{})
+	h := func(ctx context.Context, e *event.Event) error {
+		<-release
+		atomic.AddInt32(&handled, 1)
+		return nil
+	}
+	bus.Subscribe("test", "test", h)
+	defer bus.Unsubscribe("test", "test", h)
+	stream := bus.genStreamKey("test")
+	waitFor(func() bool { return conn.Exists(context.Background(), stream).Val() == 1 })
+	bus.Publish("test", "hello")
+
+	if !waitFor(func() bool { return pendingCount(t, conn, stream) == 1 }) {
+		t.Fatalf("event expected to be pending while handling")
+	}
+	close(release)
+	if !waitFor(func() bool { return pendingCount(t, conn, stream) == 0 }) {
+		t.Errorf("event expected to be acked after handling")
+	}
+	if atomic.LoadInt32(&handled) != 1 {
+		t.Errorf("event expected to be handled once")
+	}
+}
+
+func TestRedeliverFailed(t *testing.T) {
+	bus, _, conn := newMiniredisBus(t, WithClaim(20*time.Millisecond, 20*time.Millisecond))
+	var calls int32
+	h := func(ctx context.Context, e *event.Event) error {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return errors.New("temporary")
+		}
+		return nil
+	}
+	bus.Subscribe("test", "test", h)
+	defer bus.Unsubscribe("test", "test", h)
+	stream := bus.genStreamKey("test")
+	bus.Publish("test", "hello")
+
+	if !waitFor(func() bool { return atomic.LoadInt32(&calls) >= 3 && pendingCount(t, conn, stream) == 0 }) {
+		t.Errorf("failed event expected to be claimed and redelivered until success, calls %d", atomic.LoadInt32(&calls))
+	}
+}
+
+func TestPoisonEvent(t *testing.T) {
+	q := memory.NewDeadLetterQueue()
+	bus, _, conn := newMiniredisBus(t, WithClaim(20*time.Millisecond, 20*time.Millisecond), WithMaxDeliveries(2, q))
+	var calls int32
+	h := func(ctx context.Context, e *event.Event) error {
+		atomic.AddInt32(&calls, 1)
+		return errors.New("always")
+	}
+	bus.Subscribe("test", "test", h)
+	defer bus.Unsubscribe("test", "test", h)
+	stream := bus.genStreamKey("test")
+	bus.Publish("test", "hello")
+
+	var dls []*event.DeadLetter
+	if !waitFor(func() bool { dls, _ = q.List(context.Background(), 0); return len(dls) == 1 }) {
+		t.Fatalf("poison event expected to be dead lettered")
+	}
+	if dls[0].Subscriber != "test" || dls[0].Attempts != 3 {
+		t.Errorf("unexpected dead letter %+v", dls[0])
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("2 deliveries expected but got %d", n)
+	}
+	if !waitFor(func() bool { return pendingCount(t, conn, stream) == 0 }) {
+		t.Errorf("poison event expected to be acked")
+	}
+}
+
+func TestClaimFromDeadConsumer(t *testing.T) {
+	bus, _, conn := newMiniredisBus(t, WithClaim(20*time.Millisecond, 20*time.Millisecond))
+	ctx := context.Background()
+	stream := bus.genStreamKey("test")
+	conn.XGroupCreateMkStream(ctx, stream, "test", "0")
+	bus.Publish("test", "hello")
+	// a consumer reads the event and dies without acking
+	conn.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "test", Consumer: "dead", Streams: []string{stream, ">"}, Count: 1})
+
+	var handled int32
+	h := func(ctx context.Context, e *event.Event) error {
+		atomic.AddInt32(&handled, 1)
+		return nil
+	}
+	bus.Subscribe("test", "test", h)
+	defer bus.Unsubscribe("test", "test", h)
+	if !waitFor(func() bool { return atomic.LoadInt32(&handled) == 1 && pendingCount(t, conn, stream) == 0 }) {
+		t.Errorf("event of dead consumer expected to be claimed and handled")
+	}
+}
+
+func TestSlowHandlerNotClaimed(t *testing.T) {
+	bus, _, conn := newMiniredisBus(t, WithClaim(20*time.Millisecond, 50*time.Millisecond))
+	var calls int32
+	h := func(ctx context.Context, e *event.Event) error {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(300 * time.Millisecond)
+		return nil
+	}
+	bus.Subscribe("test", "test", h)
+	defer bus.Unsubscribe("test", "test", h)
+	stream := bus.genStreamKey("test")
+	waitFor(func() bool { return conn.Exists(context.Background(), stream).Val() == 1 })
+	bus.Publish("test", "hello")
+
+	if !waitFor(func() bool { return pendingCount(t, conn, stream) == 0 }) {
+		t.Fatalf("event expected to be acked after handling")
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("event in flight expected to be handled once but got %d calls", n)
+	}
+}
+
+func TestDeliveryCounts(t *testing.T) {
+	bus, _, conn := newMiniredisBus(t)
+	ctx := context.Background()
+	stream := bus.genStreamKey("test")
+	conn.XGroupCreateMkStream(ctx, stream, "test", "0")
+	for i := 0; i < 4; i++ {
+		bus.Publish("test", i)
+	}
+	streams, _ := conn.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "test", Consumer: "x", Streams: []string{stream, ">"}, Count: 4}).Result()
+	if len(streams) != 1 || len(streams[0].Messages) != 4 {
+		t.Fatalf("4 events expected to be read")
+	}
+	msgs := streams[0].Messages
+	// the 1st and 3rd entries are delivered again, the others in between are not
+	conn.XClaim(ctx, &redis.XClaimArgs{Stream: stream, Group: "test", Consumer: "x", Messages: []string{msgs[0].ID}})
+	conn.XClaim(ctx, &redis.XClaimArgs{Stream: stream, Group: "test", Consumer: "x", Messages: []string{msgs[2].ID}})
+	conn.XClaim(ctx, &redis.XClaimArgs{Stream: stream, Group: "test", Consumer: "x", Messages: []string{msgs[2].ID}})
+
+	c := &eventConsumer{bus: bus, eventType: "test"}
+	counts := c.deliveryCounts(ctx, stream, []redis.XMessage{msgs[0], msgs[2]})
+	if len(counts) != 2 || counts[msgs[0].ID] != 2 || counts[msgs[2].ID] != 3 {
+		t.Errorf("unexpected delivery counts %v", counts)
+	}
+}
+
+func TestClose(t *testing.T) {
+	s := miniredis.RunT(t)
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+	bus := NewEventBusWithOptions(&redis.UniversalOptions{Addrs: []string{s.Addr()}}, 100, "test")
+	var handled int32
+	h := func(ctx context.Context, e *event.Event) error {
+		time.Sleep(50 * time.Millisecond)
+		atomic.AddInt32(&handled, 1)
+		return nil
+	}
+	bus.Subscribe("test", "test", h)
+	bus.Subscribe("other", "test", h)
+	bus.Publish("test", "hello")
+	waitFor(func() bool { return s.Exists("__event__:test") })
+	time.Sleep(20 * time.Millisecond) // event in progress
+
+	if err := bus.Close(context.Background()); err != nil {
+		t.Errorf("no error expected for Close but got '%v'", err)
+	}
+	if n := atomic.LoadInt32(&handled); n != 1 {
+		t.Errorf("event in progress expected to be handled but got %d", n)
+	}
+	if err := bus.Publish("test", "late"); err != event.ErrClosed {
+		t.Errorf("ErrClosed expected after Close but got '%v'", err)
+	}
+	if err := bus.Subscribe("test", "late", h); err != event.ErrClosed {
+		t.Errorf("ErrClosed expected after Close but got '%v'", err)
+	}
+}
+
+func TestPublishBatch(t *testing.T) {
+	bus, _, conn := newMiniredisBus(t)
+	var events []*event.Event
+	for _, tp := range []string{"a", "a", "b"} {
+		e, _ := event.NewEvent(tp, "hello")
+		events = append(events, e)
+	}
+	if err := bus.PublishBatch(events...); err != nil {
+		t.Fatalf("no error expected for PublishBatch but got '%v'", err)
+	}
+	ctx := context.Background()
+	if n := conn.XLen(ctx, bus.genStreamKey("a")).Val(); n != 2 {
+		t.Errorf("2 events expected in stream a but got %d", n)
+	}
+	msgs := conn.XRange(ctx, bus.genStreamKey("b"), "-", "+").Val()
+	if len(msgs) != 1 || msgs[0].Values["id"] != events[2].Id().String() {
+		t.Errorf("event expected in stream b but got %v", msgs)
+	}
+}
+
+func TestOrderingByKey(t *testing.T) {
+	bus, _, _ := newMiniredisBus(t)
+	var lock sync.Mutex
+	seen := map[string][]int{}
+	var total, running, maxRunning int32
+	h := func(ctx context.Context, e *event.Event) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		var seq int
+		json.Unmarshal(e.Payload(), &seq)
+		lock.Lock()
+		seen[e.Meta().Key] = append(seen[e.Meta().Key], seq)
+		lock.Unlock()
+		atomic.AddInt32(&total, 1)
+		return nil
+	}
+	bus.Subscribe("test", "test", h, event.WithConcurrency(4))
+	for i := 0; i < 10; i++ {
+		for _, k := range []string{"a", "b", "c", "d"} {
+			bus.Publish("test", i, event.WithKey(k))
+		}
+	}
+	if !waitFor(func() bool { return atomic.LoadInt32(&total) == 40 }) {
+		t.Fatalf("40 events expected to be handled but got %d", atomic.LoadInt32(&total))
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	for k, seqs := range seen {
+		for i, seq := range seqs {
+			if seq != i {
+				t.Errorf("events of key %s expected in order but got %v", k, seqs)
+				break
+			}
+		}
+	}
+	if atomic.LoadInt32(&maxRunning) < 2 {
+		t.Errorf("events of different keys expected to be handled in parallel")
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	bus, _, _ := newMiniredisBus(t)
+	got := make(chan context.Context, 1)
+	h := func(ctx context.Context, e *event.Event) error {
+		got <- ctx
+		return nil
+	}
+	bus.Subscribe("test", "test", h)
+	ctx := event.ContextWithHeaders(context.Background(), event.Headers{event.HeaderTenant: "t1"})
+	bus.Publish("test", "hello", event.WithContext(ctx), event.WithHeader("custom", "value"))
+	select {
+	case ctx := <-got:
+		e := event.FromContext(ctx)
+		if e.Meta().Tenant() != "t1" || e.Meta().Header("custom") != "value" || e.Meta().CorrelationId() != e.Id().String() {
+			t.Errorf("headers expected to be restored but got %v", e.Meta().Headers)
+		}
+		if event.HeadersFromContext(ctx)[event.HeaderTenant] != "t1" {
+			t.Errorf("tenant expected in handler context")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("event expected to be handled")
+	}
+}
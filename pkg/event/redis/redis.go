@@ -3,9 +3,8 @@ package redis
 import (
 	"context"
 	"fmt"
-	"reflect"
-	"runtime/debug"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,103 +15,300 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
-type eventHandlerWrapper struct {
-	bus        *redisEventBus
-	moduleName string
-	callback   reflect.Value
-	fn         event.EventHandler
-	events     chan *event.Event
-}
-
-func (h *eventHandlerWrapper) start() {
-	for e := range h.events {
-		h.handle(e)
-	}
-}
-
-func (h *eventHandlerWrapper) handle(e *event.Event) {
-	defer func() {
-		if err := recover(); err != nil {
-			log.Error("[event-redis] Got error while handling event:", e)
-			log.Error(err, string(debug.Stack()))
-		}
-	}()
-	h.fn(e)
-}
-
-func (h *eventHandlerWrapper) stop() {
-	if h.events != nil {
-		close(h.events)
-	}
-}
-
-const consumerBufferSize int64 = 1000
+// Timeout of blocking read, cancellation does not interrupt a blocking read so it bounds the time to stop
+const readBlockTimeout = time.Second
 
 type eventConsumer struct {
 	bus       *redisEventBus
 	eventType string
-	handlers  []*eventHandlerWrapper
+	handlers  []*event.Subscription
 	cancel    context.CancelFunc
+	done      chan struct{}
+	// messages waiting for handlers to be acked
+	inflight sync.WaitGroup
+	// ids of messages in flight, their idle time is refreshed so that they are not claimed meanwhile
+	inflightIds map[string]struct{}
+	idsLock     sync.Mutex
 }
 
-func (c *eventConsumer) start() {
-	ctx, cancel := context.WithCancel(context.Background())
-	c.cancel = cancel
+// Number of messages read at once
+const readCount = 10
+
+func (c *eventConsumer) start(ctx context.Context) {
+	defer close(c.done)
+	defer c.inflight.Wait()
 	streamKey := c.bus.genStreamKey(c.eventType)
 	for {
 		log.Debugf("[event-redis] Trying to create consumer group: %s %s", streamKey, c.bus.group)
-		err := c.bus.conn.XGroupCreate(ctx, streamKey, c.bus.group, "0").Err()
+		err := c.bus.conn.XGroupCreateMkStream(ctx, streamKey, c.bus.group, "0").Err()
 		if err != nil && err.Error() != "BUSYGROUP Consumer Group name already exists" {
 			log.Warnf("[event-redis] Got error while creating consumer group: %v", err)
-			time.Sleep(time.Second * 10)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second * 10):
+			}
 		} else {
 			break
 		}
 	}
 	cid := uuid.NewString()
-	for {
+	nextClaim := time.Now()
+	nextRefresh := time.Now().Add(c.bus.claimMinIdle / 2)
+	for ctx.Err() == nil {
+		if !time.Now().Before(nextRefresh) {
+			c.refresh(ctx, streamKey, cid)
+			nextRefresh = time.Now().Add(c.bus.claimMinIdle / 2)
+		}
+		if !time.Now().Before(nextClaim) {
+			c.claim(ctx, streamKey, cid)
+			nextClaim = time.Now().Add(c.bus.claimInterval)
+		}
+		// zero block means blocking forever, so it is a millisecond at least
+		block := readBlockTimeout
+		if d := time.Until(nextClaim); d < block {
+			block = d
+		}
+		if d := time.Until(nextRefresh); d < block {
+			block = d
+		}
+		block = max(block, time.Millisecond)
 		log.Debugf("[event-redis] Trying to read streams from redis: %s %s %s", streamKey, c.bus.group, cid)
-		// TODO context.cancel cannot stop XReadGroup block
-		if streams, err := c.bus.conn.XReadGroup(ctx, &redis.XReadGroupArgs{
+		streams, err := c.bus.conn.XReadGroup(ctx, &redis.XReadGroupArgs{
 			Group:    c.bus.group,
 			Consumer: cid,
-			Count:    1,
+			Count:    readCount,
 			Streams:  []string{streamKey, ">"},
-			Block:    0,
-		}).Result(); err != nil {
-			if err == context.Canceled { // context.cancel cannot stop XReadGroup block, this error might be not received ever
-				log.Infof("[event-redis] Consumer group %s canceled", c.bus.group)
+			Block:    block,
+		}).Result()
+		if err != nil {
+			if err == redis.Nil {
+				continue
+			}
+			if ctx.Err() != nil {
 				break
 			}
 			log.Warnf("[event-redis] Got error while consuming events: %v", err)
-		} else {
-			if len(c.handlers) == 0 { // no handlers, break the loop
-				break
+			continue
+		}
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				c.process(ctx, streamKey, msg)
+			}
+		}
+	}
+	log.Infof("[event-redis] Consumer of %s in group %s canceled", c.eventType, c.bus.group)
+}
+
+// Claim entries pending for long from dead or failed consumers, entries delivered too many times are poison
+// and put into dead letter sink instead of handling
+func (c *eventConsumer) claim(ctx context.Context, streamKey, cid string) {
+	start := "0-0"
+	for {
+		msgs, next, err := c.bus.conn.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   streamKey,
+			Group:    c.bus.group,
+			Consumer: cid,
+			MinIdle:  c.bus.claimMinIdle,
+			Start:    start,
+			Count:    claimBatchSize,
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Warnf("[event-redis] Got error while claiming pending events: %v", err)
+			}
+			return
+		}
+		deliveries := c.deliveryCounts(ctx, streamKey, msgs)
+		for _, msg := range msgs {
+			if c.tracked(msg.ID) {
+				continue // it is being handled, and its idle time is reset by claiming
+			}
+			if n := deliveries[msg.ID]; c.bus.maxDeliveries > 0 && n > int64(c.bus.maxDeliveries) {
+				c.poison(ctx, streamKey, msg, n)
+				continue
+			}
+			c.process(ctx, streamKey, msg)
+		}
+		if next == "0-0" || next == "" || ctx.Err() != nil {
+			return
+		}
+		start = next
+	}
+}
+
+// Number of pending entries claimed in one round
+const claimBatchSize = 100
+
+// Get delivery counts of pending entries, each entry is looked up by its id since entries
+// between them might be not idle enough to be claimed
+func (c *eventConsumer) deliveryCounts(ctx context.Context, streamKey string, msgs []redis.XMessage) map[string]int64 {
+	counts := make(map[string]int64, len(msgs))
+	if len(msgs) == 0 || c.bus.maxDeliveries <= 0 {
+		return counts
+	}
+	cmds := make([]*redis.XPendingExtCmd, len(msgs))
+	_, err := c.bus.conn.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, msg := range msgs {
+			cmds[i] = p.XPendingExt(ctx, &redis.XPendingExtArgs{
+				Stream: streamKey,
+				Group:  c.bus.group,
+				Start:  msg.ID,
+				End:    msg.ID,
+				Count:  1,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		log.Warnf("[event-redis] Got error while getting delivery counts: %v", err)
+		return counts
+	}
+	for _, cmd := range cmds {
+		for _, p := range cmd.Val() {
+			counts[p.ID] = p.RetryCount
+		}
+	}
+	return counts
+}
+
+// Track message in flight, false is returned if it is already in flight
+func (c *eventConsumer) track(id string) bool {
+	c.idsLock.Lock()
+	defer c.idsLock.Unlock()
+	if _, ok := c.inflightIds[id]; ok {
+		return false
+	}
+	c.inflightIds[id] = struct{}{}
+	return true
+}
+
+func (c *eventConsumer) untrack(id string) {
+	c.idsLock.Lock()
+	defer c.idsLock.Unlock()
+	delete(c.inflightIds, id)
+}
+
+func (c *eventConsumer) tracked(id string) bool {
+	c.idsLock.Lock()
+	defer c.idsLock.Unlock()
+	_, ok := c.inflightIds[id]
+	return ok
+}
+
+// Reset idle time of messages in flight, so that they are not claimed by any consumer while being handled.
+// Claiming by id does not count as a delivery.
+func (c *eventConsumer) refresh(ctx context.Context, streamKey, cid string) {
+	c.idsLock.Lock()
+	ids := make([]string, 0, len(c.inflightIds))
+	for id := range c.inflightIds {
+		ids = append(ids, id)
+	}
+	c.idsLock.Unlock()
+	if len(ids) == 0 {
+		return
+	}
+	err := c.bus.conn.XClaimJustID(ctx, &redis.XClaimArgs{
+		Stream:   streamKey,
+		Group:    c.bus.group,
+		Consumer: cid,
+		Messages: ids,
+	}).Err()
+	if err != nil && ctx.Err() == nil {
+		log.Warnf("[event-redis] Got error while refreshing events in flight: %v", err)
+	}
+}
+
+func (c *eventConsumer) poison(ctx context.Context, streamKey string, msg redis.XMessage, deliveries int64) {
+	log.Errorf("[event-redis] Event %s delivered %d times is poison", msg.ID, deliveries)
+	e, err := loadEvent(msg)
+	if err == nil && c.bus.poisonSink != nil {
+		err = c.bus.poisonSink.Put(ctx, &event.DeadLetter{
+			Event:      e,
+			Subscriber: c.bus.group,
+			Error:      fmt.Sprintf("delivered %d times", deliveries),
+			Attempts:   int(deliveries),
+			Time:       time.Now(),
+		})
+		if err != nil {
+			log.Warnf("[event-redis] Got error while putting poison event: %v", err)
+			return // kept pending for the next claim
+		}
+	}
+	c.ack(ctx, streamKey, msg.ID)
+}
+
+// Dispatch message to handlers and ack it when all of them succeed, failed ones are left pending to be claimed.
+// Handlers unsubscribed meanwhile no longer need the message, unless the consumer is stopping.
+// Messages are handled concurrently across keys, so they are acked asynchronously.
+func (c *eventConsumer) process(ctx context.Context, streamKey string, msg redis.XMessage) {
+	if !c.track(msg.ID) {
+		return // it is already being handled
+	}
+	stopping := ctx
+	// the message in progress is acked even if the consumer is stopping
+	ctx = context.WithoutCancel(ctx)
+	e, err := loadEvent(msg)
+	if err != nil {
+		// the message can never be handled
+		log.Warnf("[event-redis] Got error while loading event %s: %v", msg.ID, err)
+		c.ack(ctx, streamKey, msg.ID)
+		c.untrack(msg.ID)
+		return
+	}
+	c.bus.lock.RLock()
+	handlers := append([]*event.Subscription{}, c.handlers...)
+	c.bus.lock.RUnlock()
+	log.Debug("[event-redis] Dispatch event to handlers: ", e)
+	results := make([]<-chan error, len(handlers))
+	for i, h := range handlers {
+		results[i] = h.Deliver(e)
+	}
+	c.inflight.Add(1)
+	go func() {
+		defer c.inflight.Done()
+		defer c.untrack(msg.ID)
+		failed := false
+		for _, result := range results {
+			err := <-result
+			if err == event.ErrSubscriptionStopped && stopping.Err() == nil {
+				continue
 			}
-			// log.Debug("[event-redis] Got events from: ", c.subject)
-			for _, stream := range streams {
-				for _, msg := range stream.Messages {
-					// log.Debug("[event-redis] Consume event: ", msg.ID)
-					if err := c.bus.conn.XAck(ctx, c.eventType, c.bus.group, msg.ID).Err(); err != nil {
-						log.Warnf("[event-redis] Got error while acking event: %v", err)
-					}
-					id := msg.Values["id"].(string)
-					tm, _ := strconv.ParseInt(msg.Values["time"].(string), 10, 64)
-					typee := msg.Values["type"].(string)
-					payload := msg.Values["payload"].(string)
-					e, err := event.LoadEvent(id, tm, typee, payload)
-					if err != nil {
-						log.Warnf("[event-redis] Got error while loading event: %v", err)
-						continue
-					}
-					log.Debug("[event-redis] Dispatch event to handlers: ", e)
-					for _, h := range c.handlers {
-						h.events <- e
-					}
-				}
+			if err != nil {
+				failed = true
 			}
 		}
+		if failed {
+			log.Warnf("[event-redis] Event %s is left pending for redelivery", msg.ID)
+			return
+		}
+		c.ack(ctx, streamKey, msg.ID)
+	}()
+}
+
+func (c *eventConsumer) ack(ctx context.Context, streamKey, id string) {
+	if err := c.bus.conn.XAck(ctx, streamKey, c.bus.group, id).Err(); err != nil {
+		log.Warnf("[event-redis] Got error while acking event: %v", err)
+	}
+}
+
+func loadEvent(msg redis.XMessage) (*event.Event, error) {
+	id, _ := msg.Values["id"].(string)
+	tm, _ := msg.Values["time"].(string)
+	t, _ := msg.Values["type"].(string)
+	payload, _ := msg.Values["payload"].(string)
+	key, _ := msg.Values["key"].(string)
+	headers := event.Headers{}
+	for k, v := range msg.Values {
+		if strings.HasPrefix(k, headerFieldPrefix) {
+			headers[strings.TrimPrefix(k, headerFieldPrefix)], _ = v.(string)
+		}
+	}
+	ts, err := strconv.ParseInt(tm, 10, 64)
+	if err != nil {
+		return nil, err
 	}
+	return event.LoadEvent(id, ts, t, payload, event.WithKey(key), event.WithHeaders(headers))
 }
 
 func (c *eventConsumer) stop() {
@@ -122,77 +318,179 @@ func (c *eventConsumer) stop() {
 	}
 }
 
+// Redis event bus option
+type Option func(b *redisEventBus)
+
+// Set how often and after how long idle pending events are claimed, defaults are 30s and 1m
+func WithClaim(interval, minIdle time.Duration) Option {
+	return func(b *redisEventBus) {
+		b.claimInterval = interval
+		b.claimMinIdle = minIdle
+	}
+}
+
+// Default max deliveries of an event before it is poison
+const defaultMaxDeliveries = 16
+
+// Treat events delivered more than max times as poison, they are put into sink (if not nil) and acked.
+// Default is 16 deliveries without sink, zero or negative max means unlimited deliveries.
+func WithMaxDeliveries(max int, sink event.DeadLetterSink) Option {
+	return func(b *redisEventBus) {
+		b.maxDeliveries = max
+		b.poisonSink = sink
+	}
+}
+
+// redisEventBus delivers events at least once, events are acked after all handlers succeed.
+// Events failed or left by dead consumers are claimed and redelivered to all handlers of the group,
+// so a handler failing makes the others of the group see the event again, subscribe with event.WithInbox
+// to handle it once per subscriber.
 type redisEventBus struct {
-	conn       *redis.Client
-	bufferSize int64
-	group      string
-	consumers  map[string]*eventConsumer
-	lock       *sync.RWMutex
+	event.Dispatcher
+	conn          redis.UniversalClient
+	bufferSize    int64
+	group         string
+	claimInterval time.Duration
+	claimMinIdle  time.Duration
+	maxDeliveries int
+	poisonSink    event.DeadLetterSink
+	consumers     map[string]*eventConsumer
+	lock          *sync.RWMutex
+	closed        bool
+	lifecycle     *event.Lifecycle
+	// connection created by bus is closed with it
+	ownConn bool
 }
 
-func NewEventBus(addr, password string, db int32, bufferSize int64, group string) event.EventBus {
+func NewEventBus(addr, password string, db int32, bufferSize int64, group string, opts ...Option) event.EventBus {
 	conn := redis.NewClient(&redis.Options{
 		Addr:     addr,
 		Password: password,
 		DB:       int(db),
 	})
-	return NewEventBusWithConn(conn, bufferSize, group)
+	bus := NewEventBusWithConn(conn, bufferSize, group, opts...)
+	bus.(*redisEventBus).ownConn = true
+	return bus
 }
 
-func NewEventBusWithConn(conn *redis.Client, bufferSize int64, group string) event.EventBus {
+// NewEventBusWithOptions create redis event bus with universal options, multiple addrs connect to a cluster,
+// a master name connects to sentinel
+func NewEventBusWithOptions(options *redis.UniversalOptions, bufferSize int64, group string, opts ...Option) event.EventBus {
+	log.Debug("[event-redis] connect to ", options.Addrs)
+	bus := NewEventBusWithConn(redis.NewUniversalClient(options), bufferSize, group, opts...)
+	bus.(*redisEventBus).ownConn = true
+	return bus
+}
+
+// NewEventBusWithConn create redis event bus with an existing client, cluster and failover clients are supported.
+// The client is not closed with the bus.
+func NewEventBusWithConn(conn redis.UniversalClient, bufferSize int64, group string, opts ...Option) event.EventBus {
 	bus := &redisEventBus{
-		conn:       conn,
-		bufferSize: bufferSize,
-		group:      group,
-		consumers:  map[string]*eventConsumer{},
-		lock:       new(sync.RWMutex),
+		conn:          conn,
+		bufferSize:    bufferSize,
+		group:         group,
+		claimInterval: 30 * time.Second,
+		claimMinIdle:  time.Minute,
+		maxDeliveries: defaultMaxDeliveries,
+		consumers:     map[string]*eventConsumer{},
+		lock:          new(sync.RWMutex),
+		lifecycle:     event.NewLifecycle(),
+	}
+	for _, opt := range opts {
+		opt(bus)
 	}
 	return bus
 }
 
+// Every command touches a single stream, so stream keys need no hash tag on cluster
 func (b *redisEventBus) genStreamKey(t string) string {
 	return fmt.Sprintf("__event__:%s", t)
 }
 
 // Publish implements event.EventBus.
-func (b *redisEventBus) Publish(t string, payload interface{}) error {
-	e, _ := event.NewEvent(t, payload)
+func (b *redisEventBus) Publish(t string, payload interface{}, opts ...event.PublishOption) error {
+	e, _ := event.NewEvent(t, payload, opts...)
 	log.Debug("[event-redis] Publish event: ", e)
-	if err := b.conn.XAdd(context.Background(), &redis.XAddArgs{
-		Stream: b.genStreamKey(e.Meta().Type),
-		MaxLen: b.bufferSize,
-		Values: map[string]interface{}{
-			"id":      e.Id().String(),
-			"type":    e.Meta().Type,
-			"time":    e.Meta().Time.UnixNano(),
-			"payload": string(e.Payload()),
-		},
-	}).Err(); err != nil {
+	b.lock.RLock()
+	closed := b.closed
+	b.lock.RUnlock()
+	if closed {
+		return event.ErrClosed
+	}
+	if err := b.conn.XAdd(context.Background(), b.xaddArgs(e)).Err(); err != nil {
 		log.Warnf("[event-redis] Got error while publishing event: %v", err)
 		return err
 	}
 	return nil
 }
 
+// Prefix of stream fields holding headers
+const headerFieldPrefix = "h:"
+
+func (b *redisEventBus) xaddArgs(e *event.Event) *redis.XAddArgs {
+	values := map[string]interface{}{
+		"id":      e.Id().String(),
+		"type":    e.Meta().Type,
+		"time":    e.Meta().Time.UnixNano(),
+		"payload": string(e.Payload()),
+		"key":     e.Meta().Key,
+	}
+	for k, v := range e.Meta().Headers {
+		values[headerFieldPrefix+k] = v
+	}
+	return &redis.XAddArgs{
+		Stream: b.genStreamKey(e.Meta().Type),
+		MaxLen: b.bufferSize,
+		Values: values,
+	}
+}
+
+// PublishBatch implements event.BatchPublisher, events are sent in a pipeline.
+func (b *redisEventBus) PublishBatch(events ...*event.Event) error {
+	b.lock.RLock()
+	closed := b.closed
+	b.lock.RUnlock()
+	if closed {
+		return event.ErrClosed
+	}
+	_, err := b.conn.Pipelined(context.Background(), func(p redis.Pipeliner) error {
+		for _, e := range events {
+			p.XAdd(context.Background(), b.xaddArgs(e))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Warnf("[event-redis] Got error while publishing events: %v", err)
+	}
+	return err
+}
+
 // Subscribe implements event.EventBus.
-func (b *redisEventBus) Subscribe(t string, name string, h event.EventHandler) error {
+func (b *redisEventBus) Subscribe(t string, name string, h event.EventHandler, opts ...event.SubscribeOption) error {
 	log.Debug("[event-redis] Subscribe event: ", t)
 	b.lock.Lock()
 	defer b.lock.Unlock()
+	if b.closed {
+		return event.ErrClosed
+	}
 	c, ok := b.consumers[t]
 	if !ok {
 		log.Debugf("[event-redis] Create and start consumer for event: %s", t)
 		c = &eventConsumer{
-			bus:       b,
-			eventType: t,
-			handlers:  []*eventHandlerWrapper{},
+			bus:         b,
+			eventType:   t,
+			handlers:    []*event.Subscription{},
+			done:        make(chan struct{}),
+			inflightIds: map[string]struct{}{},
 		}
 		b.consumers[t] = c
-		go c.start()
+		ctx, cancel := context.WithCancel(context.Background())
+		c.cancel = cancel
+		go c.start(ctx)
 	}
-	eh := &eventHandlerWrapper{bus: b, moduleName: name, callback: reflect.ValueOf(h), fn: h, events: make(chan *event.Event, consumerBufferSize)}
-	go eh.start()
-	c.handlers = append(c.handlers, eh)
+	s := b.NewSubscription(t, name, h, opts...)
+	b.lifecycle.Start(s)
+	c.handlers = append(c.handlers, s)
 	return nil
 }
 
@@ -201,13 +499,12 @@ func (b *redisEventBus) Unsubscribe(t string, name string, h event.EventHandler)
 	log.Debug("[event-redis] Unsubscribe event: ", t)
 	b.lock.Lock()
 	defer b.lock.Unlock()
-	rh := reflect.ValueOf(h)
 	c, ok := b.consumers[t]
 	if ok {
 		for i, k := range c.handlers {
-			if k.moduleName == name && k.callback.Pointer() == rh.Pointer() {
+			if k.Is(name, h) {
 				c.handlers = append(c.handlers[:i], c.handlers[i+1:]...)
-				k.stop()
+				k.Stop()
 				break
 			}
 		}
@@ -219,3 +516,41 @@ func (b *redisEventBus) Unsubscribe(t string, name string, h event.EventHandler)
 	}
 	return nil
 }
+
+// Close implements event.EventBus, the events in progress are handled and acked before it returns.
+// Events not acked in time are left pending and redelivered to the group later.
+func (b *redisEventBus) Close(ctx context.Context) error {
+	log.Debug("[event-redis] Close event bus")
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		return nil
+	}
+	b.closed = true
+	consumers := b.consumers
+	b.consumers = map[string]*eventConsumer{}
+	b.lock.Unlock()
+
+	for _, c := range consumers {
+		c.stop()
+	}
+	err := b.lifecycle.Close(ctx, func() {
+		for _, c := range consumers {
+			<-c.done
+		}
+	}, func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		for _, c := range consumers {
+			for _, h := range c.handlers {
+				h.Stop()
+			}
+		}
+	})
+	if b.ownConn {
+		if cerr := b.conn.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -1,6 +1,7 @@
 package redis
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"testing"
@@ -16,9 +17,10 @@ func TestEventPubSub(t *testing.T) {
 	ebus := NewEventBus("localhost:6379", "", 0, 10, "test")
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	ebus.Subscribe("test", "test", func(e *event.Event) {
+	ebus.Subscribe("test", "test", func(ctx context.Context, e *event.Event) error {
 		fmt.Println("Event:", e)
 		defer wg.Done()
+		return nil
 	})
 
 	ebus.Publish("test", "hello")
@@ -30,8 +32,9 @@ func TestEventUnsubscribe(t *testing.T) {
 	if true {
 		return
 	}
-	h := func(e *event.Event) {
+	h := func(ctx context.Context, e *event.Event) error {
 		fmt.Println("Event:", e)
+		return nil
 	}
 	ebus := NewEventBus("localhost:6379", "", 0, 10, "test")
 	ebus.Subscribe("test", "test", h)
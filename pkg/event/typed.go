@@ -0,0 +1,76 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Typer provides event type name of payload
+type Typer interface {
+	EventType() string
+}
+
+var (
+	types     = map[reflect.Type]string{}
+	typesLock sync.RWMutex
+)
+
+// Register event type name of payload type T, it is used if T does not implement Typer
+func Register[T any](name string) {
+	typesLock.Lock()
+	defer typesLock.Unlock()
+	types[reflect.TypeOf((*T)(nil)).Elem()] = name
+}
+
+// Get event type name of payload type T, from EventType method of T (or *T) or the registry
+func TypeOf[T any]() (string, error) {
+	var zero T
+	if t, ok := any(zero).(Typer); ok {
+		return t.EventType(), nil
+	}
+	if t, ok := any(new(T)).(Typer); ok {
+		return t.EventType(), nil
+	}
+	rt := reflect.TypeOf((*T)(nil)).Elem()
+	typesLock.RLock()
+	defer typesLock.RUnlock()
+	if name, ok := types[rt]; ok {
+		return name, nil
+	}
+	return "", fmt.Errorf("event type of %s is not registered", rt)
+}
+
+// Publish payload of type T
+func Publish[T any](bus EventBus, payload T, opts ...PublishOption) error {
+	t, err := TypeOf[T]()
+	if err != nil {
+		return err
+	}
+	return bus.Publish(t, payload, opts...)
+}
+
+// Typed event handler
+type TypedHandler[T any] func(ctx context.Context, payload T, meta Meta) error
+
+// Subscribe handler of payload type T, payloads failed to decode are not retried and go to dead letter directly.
+// The returned handler is used to unsubscribe.
+func Subscribe[T any](bus EventBus, name string, h TypedHandler[T], opts ...SubscribeOption) (EventHandler, error) {
+	t, err := TypeOf[T]()
+	if err != nil {
+		return nil, err
+	}
+	eh := func(ctx context.Context, e *Event) error {
+		var payload T
+		if err := json.Unmarshal(e.Payload(), &payload); err != nil {
+			return Permanent(fmt.Errorf("decode %s: %w", e.Meta().Type, err))
+		}
+		return h(ctx, payload, e.Meta())
+	}
+	if err := bus.Subscribe(t, name, eh, opts...); err != nil {
+		return nil, err
+	}
+	return eh, nil
+}
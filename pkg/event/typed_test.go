@@ -0,0 +1,108 @@
+package event_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/event"
+	"github.com/ofavor/ddd-go/pkg/event/memory"
+)
+
+type OrderCreated struct {
+	Id    string `json:"id"`
+	Total int    `json:"total"`
+}
+
+func (OrderCreated) EventType() string {
+	return "order.created"
+}
+
+type OrderPaid struct {
+	Id string `json:"id"`
+}
+
+func (*OrderPaid) EventType() string {
+	return "order.paid"
+}
+
+type UserCreated struct {
+	Name string `json:"name"`
+}
+
+func TestTypeOf(t *testing.T) {
+	event.Register[UserCreated]("user.created")
+	cases := map[string]func() (string, error){
+		"order.created": event.TypeOf[OrderCreated],
+		"order.paid":    event.TypeOf[OrderPaid],
+		"user.created":  event.TypeOf[UserCreated],
+	}
+	for expected, f := range cases {
+		if name, err := f(); err != nil || name != expected {
+			t.Errorf("'%s' expected but got '%s', %v", expected, name, err)
+		}
+	}
+	if _, err := event.TypeOf[struct{ X int }](); err == nil {
+		t.Errorf("error expected for unregistered type")
+	}
+}
+
+func TestTypedPubSub(t *testing.T) {
+	bus := memory.NewEventBus(10)
+	got := make(chan OrderCreated, 1)
+	_, err := event.Subscribe(bus, "test", func(ctx context.Context, o OrderCreated, meta event.Meta) error {
+		if meta.Type != "order.created" || meta.Key != "o1" {
+			t.Errorf("unexpected meta %+v", meta)
+		}
+		got <- o
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("no error expected for Subscribe but got '%v'", err)
+	}
+	if err := event.Publish(bus, OrderCreated{Id: "o1", Total: 10}, event.WithKey("o1")); err != nil {
+		t.Fatalf("no error expected for Publish but got '%v'", err)
+	}
+	bus.Close(context.Background())
+	select {
+	case o := <-got:
+		if o.Id != "o1" || o.Total != 10 {
+			t.Errorf("decoded payload expected but got %+v", o)
+		}
+	default:
+		t.Errorf("event expected to be handled")
+	}
+}
+
+func TestTypedDecodeFailure(t *testing.T) {
+	bus := memory.NewEventBus(10)
+	q := memory.NewDeadLetterQueue()
+	calls := 0
+	_, err := event.Subscribe(bus, "test", func(ctx context.Context, o OrderCreated, meta event.Meta) error {
+		calls++
+		return nil
+	}, event.WithRetry(event.RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour}), event.WithDeadLetter(q))
+	if err != nil {
+		t.Fatalf("no error expected for Subscribe but got '%v'", err)
+	}
+	bus.Publish("order.created", "not an order")
+	bus.Close(context.Background())
+	if calls != 0 {
+		t.Errorf("handler should not be called for undecodable payload")
+	}
+	dls, _ := q.List(context.Background(), 0)
+	if len(dls) != 1 || dls[0].Attempts != 1 {
+		t.Fatalf("1 dead letter without retry expected but got %v", dls)
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	err := errors.New("bad")
+	if !event.IsPermanent(event.Permanent(err)) || !errors.Is(event.Permanent(err), err) {
+		t.Errorf("permanent error expected to wrap the error")
+	}
+	if event.IsPermanent(err) || event.Permanent(nil) != nil {
+		t.Errorf("unexpected permanent error")
+	}
+}
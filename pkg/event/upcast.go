@@ -0,0 +1,72 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// Upcaster transforms payload of a schema version to the next one
+type Upcaster func(payload json.RawMessage) (json.RawMessage, error)
+
+var (
+	upcasters     = map[string]map[int]Upcaster{}
+	upcastersLock sync.RWMutex
+)
+
+// Register upcaster of event type from version to version+1, versions start from 0.
+// The current version of event type is the one after the latest upcaster, it is stamped on new events.
+func RegisterUpcaster(t string, from int, u Upcaster) {
+	upcastersLock.Lock()
+	defer upcastersLock.Unlock()
+	if upcasters[t] == nil {
+		upcasters[t] = map[int]Upcaster{}
+	}
+	upcasters[t][from] = u
+}
+
+// Get current schema version of event type, 0 if it has no upcaster
+func CurrentVersion(t string) int {
+	upcastersLock.RLock()
+	defer upcastersLock.RUnlock()
+	v := 0
+	for from := range upcasters[t] {
+		if from+1 > v {
+			v = from + 1
+		}
+	}
+	return v
+}
+
+// Upcast event to the current version of its type, the event is returned as is if it is up to date.
+// Events of newer versions are not touched.
+func Upcast(e *Event) (*Event, error) {
+	from := e.meta.SchemaVersion()
+	// steps are copied with lock held, since upcasters might be registered meanwhile
+	upcastersLock.RLock()
+	var steps []Upcaster
+	for u, ok := upcasters[e.meta.Type][from]; ok; u, ok = upcasters[e.meta.Type][from+len(steps)] {
+		steps = append(steps, u)
+	}
+	upcastersLock.RUnlock()
+	if len(steps) == 0 {
+		return e, nil
+	}
+	payload := e.payload
+	for i, u := range steps {
+		var err error
+		if payload, err = u(payload); err != nil {
+			return nil, fmt.Errorf("upcast %s from version %d: %w", e.meta.Type, from+i, err)
+		}
+	}
+	v := from + len(steps)
+	meta := e.meta
+	meta.Headers = meta.Headers.Clone()
+	meta.Headers[HeaderSchemaVersion] = strconv.Itoa(v)
+	return &Event{
+		id:      e.id,
+		meta:    meta,
+		payload: payload,
+	}, nil
+}
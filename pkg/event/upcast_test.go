@@ -0,0 +1,127 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func init() {
+	// v0 {"name": "a b"} -> v1 {"first": "a", "last": "b"} -> v2 adds "full"
+	RegisterUpcaster("test.upcast", 0, func(payload json.RawMessage) (json.RawMessage, error) {
+		v := map[string]string{}
+		if err := json.Unmarshal(payload, &v); err != nil {
+			return nil, err
+		}
+		var first, last string
+		for i, c := range v["name"] {
+			if c == ' ' {
+				first, last = v["name"][:i], v["name"][i+1:]
+				break
+			}
+		}
+		return json.Marshal(map[string]string{"first": first, "last": last})
+	})
+	RegisterUpcaster("test.upcast", 1, func(payload json.RawMessage) (json.RawMessage, error) {
+		v := map[string]string{}
+		if err := json.Unmarshal(payload, &v); err != nil {
+			return nil, err
+		}
+		v["full"] = v["first"] + " " + v["last"]
+		return json.Marshal(v)
+	})
+	RegisterUpcaster("test.upcast.fail", 0, func(payload json.RawMessage) (json.RawMessage, error) {
+		return nil, errors.New("unsupported")
+	})
+}
+
+func TestCurrentVersion(t *testing.T) {
+	if v := CurrentVersion("test.upcast"); v != 2 {
+		t.Errorf("current version expected to be 2 but got %d", v)
+	}
+	if v := CurrentVersion("test.none"); v != 0 {
+		t.Errorf("current version expected to be 0 without upcasters but got %d", v)
+	}
+	e, _ := NewEvent("test.upcast", map[string]string{"first": "a", "last": "b", "full": "a b"})
+	if e.Meta().SchemaVersion() != 2 {
+		t.Errorf("new event expected to be stamped with current version but got %d", e.Meta().SchemaVersion())
+	}
+}
+
+func TestUpcast(t *testing.T) {
+	old, _ := LoadEvent("0b2f1c4e-7d7a-4d4f-9a43-2d1b3c4d5e6f", 0, "test.upcast", `{"name":"a b"}`)
+	e, err := Upcast(old)
+	if err != nil {
+		t.Fatalf("no error expected but got '%v'", err)
+	}
+	v := map[string]string{}
+	json.Unmarshal(e.Payload(), &v)
+	if v["first"] != "a" || v["last"] != "b" || v["full"] != "a b" {
+		t.Errorf("payload expected to be upcasted but got %s", e.Payload())
+	}
+	if e.Meta().SchemaVersion() != 2 || e.Id() != old.Id() {
+		t.Errorf("version 2 with the same id expected but got %d", e.Meta().SchemaVersion())
+	}
+	if old.Meta().SchemaVersion() != 0 {
+		t.Errorf("original event should not be modified")
+	}
+	current, _ := NewEvent("test.upcast", v)
+	if e1, _ := Upcast(current); e1 != current {
+		t.Errorf("up-to-date event expected to be returned as is")
+	}
+}
+
+func TestHandleUpcasted(t *testing.T) {
+	old, _ := LoadEvent("0b2f1c4e-7d7a-4d4f-9a43-2d1b3c4d5e6f", 0, "test.upcast", `{"name":"a b"}`)
+	var got *Event
+	h := func(ctx context.Context, e *Event) error {
+		got = e
+		return nil
+	}
+	if err := Handle(context.Background(), old, "sub", h, NewSubscribeOptions()); err != nil {
+		t.Fatalf("no error expected but got '%v'", err)
+	}
+	if got.Meta().SchemaVersion() != 2 {
+		t.Errorf("handler expected to see upcasted event but got version %d", got.Meta().SchemaVersion())
+	}
+}
+
+func TestHandleUpcastFailure(t *testing.T) {
+	old, _ := LoadEvent("0b2f1c4e-7d7a-4d4f-9a43-2d1b3c4d5e6f", 0, "test.upcast.fail", `{}`)
+	q := &sliceQueue{}
+	calls := 0
+	h := func(ctx context.Context, e *Event) error {
+		calls++
+		return nil
+	}
+	o := NewSubscribeOptions(WithRetry(RetryPolicy{MaxAttempts: 3}), WithDeadLetter(q))
+	Handle(context.Background(), old, "sub", h, o)
+	if calls != 0 || len(q.letters) != 1 || q.letters[0].Attempts != 1 {
+		t.Errorf("event failed to upcast expected to be dead lettered without retry")
+	}
+	if q.letters[0].Event.Meta().SchemaVersion() != 0 {
+		t.Errorf("original event expected in dead letter")
+	}
+}
+
+func TestUpcastConcurrentRegister(t *testing.T) {
+	identity := func(payload json.RawMessage) (json.RawMessage, error) {
+		return payload, nil
+	}
+	RegisterUpcaster("test.concurrent", 0, identity)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i < 100; i++ {
+			RegisterUpcaster("test.concurrent", i, identity)
+		}
+	}()
+	e, _ := NewEvent("test.concurrent", "hello", WithSchemaVersion(0))
+	for i := 0; i < 100; i++ {
+		if _, err := Upcast(e); err != nil {
+			t.Errorf("no error expected for Upcast but got '%v'", err)
+		}
+	}
+	<-done
+}
@@ -0,0 +1,198 @@
+package leader
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/log"
+	"github.com/ofavor/ddd-go/pkg/mutex"
+
+	"github.com/google/uuid"
+)
+
+// Elector option
+type Option func(e *Elector)
+
+// Set the identity of the elector, a random uuid is used by default
+func WithId(id string) Option {
+	return func(e *Elector) {
+		e.id = id
+	}
+}
+
+// Set the interval for campaign and renewal, it must be shorter than the ttl.
+// One third of the ttl is used by default or if the interval is invalid.
+func WithInterval(interval time.Duration) Option {
+	return func(e *Elector) {
+		e.interval = interval
+	}
+}
+
+// Elector elects one leader among instances by holding a lock with renewal
+type Elector struct {
+	m         mutex.ReentrantMutex
+	key       string
+	id        string
+	ttl       time.Duration
+	interval  time.Duration
+	leader    atomic.Bool
+	leaseAt   atomic.Int64 // start time of the latest successful campaign or renewal in unix nanoseconds
+	onElected func()
+	onRevoked func()
+	lock      *sync.Mutex
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// Create leader elector, the lock of key is held by leader and expires after ttl if not renewed
+func NewElector(m mutex.ReentrantMutex, key string, ttl time.Duration, opts ...Option) *Elector {
+	if ttl <= 0 {
+		panic("[leader] Elector ttl must be positive")
+	}
+	e := &Elector{
+		m:    m,
+		key:  key,
+		id:   uuid.NewString(),
+		ttl:  ttl,
+		lock: new(sync.Mutex),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.interval <= 0 || e.interval >= ttl {
+		e.interval = ttl / 3
+	}
+	if e.interval <= 0 {
+		e.interval = ttl
+	}
+	return e
+}
+
+// Get elector identity
+func (e *Elector) Id() string {
+	return e.id
+}
+
+// Check if current elector is the leader.
+// Leadership is considered lost once the ttl has passed since the latest successful renewal.
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load() && time.Since(time.Unix(0, e.leaseAt.Load())) < e.ttl
+}
+
+// Set the callback when elected as leader, it is called in election goroutine and should not block
+func (e *Elector) OnElected(f func()) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.onElected = f
+}
+
+// Set the callback when leadership is revoked, it is called in election goroutine and should not block
+func (e *Elector) OnRevoked(f func()) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.onRevoked = f
+}
+
+// Start election in background
+func (e *Elector) Start() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.stop != nil {
+		return
+	}
+	e.stop = make(chan struct{})
+	e.done = make(chan struct{})
+	go e.run(e.stop, e.done)
+}
+
+// Stop election, leader steps down and releases the lock
+func (e *Elector) Stop() {
+	e.lock.Lock()
+	stop, done := e.stop, e.done
+	e.stop, e.done = nil, nil
+	e.lock.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+func (e *Elector) run(stop, done chan struct{}) {
+	defer close(done)
+	ctx := mutex.WithOwner(context.Background(), e.id)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		if e.leader.Load() {
+			e.renew(ctx)
+		} else {
+			e.campaign(ctx)
+		}
+		select {
+		case <-stop:
+			if e.leader.Load() {
+				e.stepDown(ctx)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Each lock call must finish within the interval, so that it never outlives the lease
+func (e *Elector) call(ctx context.Context, f func(ctx context.Context) error) (time.Time, error) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, e.interval)
+	defer cancel()
+	return start, f(ctx)
+}
+
+func (e *Elector) campaign(ctx context.Context) {
+	start, err := e.call(ctx, func(ctx context.Context) error {
+		return e.m.LockContext(ctx, e.key, e.ttl)
+	})
+	if err != nil {
+		return
+	}
+	log.Infof("[leader] Elector '%s' is elected as leader of '%s'", e.id, e.key)
+	e.leaseAt.Store(start.UnixNano())
+	e.leader.Store(true)
+	e.lock.Lock()
+	f := e.onElected
+	e.lock.Unlock()
+	if f != nil {
+		f()
+	}
+}
+
+func (e *Elector) renew(ctx context.Context) {
+	start, err := e.call(ctx, func(ctx context.Context) error {
+		return e.m.RenewContext(ctx, e.key, e.ttl)
+	})
+	if err != nil {
+		log.Warnf("[leader] Elector '%s' failed to renew leadership of '%s': %v", e.id, e.key, err)
+		e.stepDown(ctx)
+		return
+	}
+	e.leaseAt.Store(start.UnixNano())
+}
+
+func (e *Elector) stepDown(ctx context.Context) {
+	// release the hold taken by campaign
+	if _, err := e.call(ctx, func(ctx context.Context) error {
+		return e.m.UnlockContext(ctx, e.key)
+	}); err != nil && err != mutex.ErrNotHeld {
+		log.Warnf("[leader] Elector '%s' got error while releasing leadership of '%s': %v", e.id, e.key, err)
+	}
+	log.Infof("[leader] Elector '%s' steps down from leader of '%s'", e.id, e.key)
+	e.leader.Store(false)
+	e.lock.Lock()
+	f := e.onRevoked
+	e.lock.Unlock()
+	if f != nil {
+		f()
+	}
+}
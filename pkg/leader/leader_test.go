@@ -0,0 +1,149 @@
+package leader
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/mutex"
+	"github.com/ofavor/ddd-go/pkg/mutex/local"
+	mutexredis "github.com/ofavor/ddd-go/pkg/mutex/redis"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func waitFor(cond func() bool, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return cond()
+}
+
+func testElection(t *testing.T, m mutex.ReentrantMutex) {
+	key := "leader.test"
+	ttl := 300 * time.Millisecond
+	var elected, revoked atomic.Int32
+	e1 := NewElector(m, key, ttl, WithId("e1"), WithInterval(20*time.Millisecond))
+	e1.OnElected(func() { elected.Add(1) })
+	e1.OnRevoked(func() { revoked.Add(1) })
+	e2 := NewElector(m, key, ttl, WithId("e2"), WithInterval(20*time.Millisecond))
+	e2.OnElected(func() { elected.Add(1) })
+	e2.OnRevoked(func() { revoked.Add(1) })
+
+	e1.Start()
+	if !waitFor(e1.IsLeader, time.Second) {
+		t.Fatal("e1 should be elected as leader")
+	}
+	e2.Start()
+	time.Sleep(ttl) // e1 keeps renewing across the ttl
+	if !e1.IsLeader() || e2.IsLeader() {
+		t.Fatal("e1 should still be the only leader")
+	}
+
+	e1.Stop()
+	if e1.IsLeader() {
+		t.Error("e1 should step down after stop")
+	}
+	if revoked.Load() != 1 {
+		t.Errorf("expected 1 revoked callback but got %d", revoked.Load())
+	}
+	if !waitFor(e2.IsLeader, time.Second) {
+		t.Fatal("e2 should be elected as leader after e1 stepped down")
+	}
+	e2.Stop()
+	if elected.Load() != 2 || revoked.Load() != 2 {
+		t.Errorf("expected 2 elected and 2 revoked callbacks but got %d and %d", elected.Load(), revoked.Load())
+	}
+	if err := m.Lock(key, time.Second); err != nil {
+		t.Errorf("lock should be released after all electors stopped but got '%v'", err)
+	}
+}
+
+func TestElectionWithLocalMutex(t *testing.T) {
+	testElection(t, local.NewMutex())
+}
+
+func TestElectionWithRedisMutex(t *testing.T) {
+	s := miniredis.RunT(t)
+	conn := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer conn.Close()
+	testElection(t, mutexredis.NewMutex(conn))
+}
+
+func TestRevokedWhenLockLost(t *testing.T) {
+	m := local.NewMutex()
+	key := "leader.test"
+	e := NewElector(m, key, time.Second, WithInterval(20*time.Millisecond))
+	revoked := make(chan struct{}, 1)
+	e.OnRevoked(func() { revoked <- struct{}{} })
+	e.Start()
+	defer e.Stop()
+	if !waitFor(e.IsLeader, time.Second) {
+		t.Fatal("elector should be elected as leader")
+	}
+	m.Unlock(key)
+	m.Lock(key, time.Second) // taken by someone else
+	select {
+	case <-revoked:
+	case <-time.After(time.Second):
+		t.Fatal("leadership should be revoked when lock is lost")
+	}
+	if e.IsLeader() {
+		t.Error("elector should not be leader any more")
+	}
+}
+
+// blockingMutex simulates a backend which hangs on renewal
+type blockingMutex struct {
+	mutex.ReentrantMutex
+	block chan struct{}
+}
+
+func (m *blockingMutex) RenewContext(ctx context.Context, key string, expiration time.Duration) error {
+	select {
+	case <-m.block:
+	case <-ctx.Done():
+	}
+	return ctx.Err()
+}
+
+func TestLeadershipLostWhenRenewalHangs(t *testing.T) {
+	m := &blockingMutex{ReentrantMutex: local.NewMutex(), block: make(chan struct{})}
+	ttl := 100 * time.Millisecond
+	e := NewElector(m, "leader.test", ttl, WithInterval(80*time.Millisecond))
+	e.Start()
+	defer e.Stop()
+	if !waitFor(e.IsLeader, time.Second) {
+		t.Fatal("elector should be elected as leader")
+	}
+	time.Sleep(ttl + 20*time.Millisecond)
+	if e.IsLeader() {
+		t.Error("leadership should be lost once ttl passed without renewal")
+	}
+}
+
+func TestInvalidTTL(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("panic expected for non-positive ttl")
+		}
+	}()
+	NewElector(local.NewMutex(), "leader.test", 0)
+}
+
+func TestInvalidInterval(t *testing.T) {
+	e := NewElector(local.NewMutex(), "leader.test", time.Second, WithInterval(0))
+	if e.interval != time.Second/3 {
+		t.Errorf("expected default interval but got %s", e.interval)
+	}
+	e = NewElector(local.NewMutex(), "leader.test", time.Second, WithInterval(2*time.Second))
+	if e.interval != time.Second/3 {
+		t.Errorf("expected default interval but got %s", e.interval)
+	}
+}
@@ -0,0 +1,29 @@
+package metrics
+
+import "time"
+
+// Metric labels, the label names of a metric must be the same on every call
+type Labels map[string]string
+
+// Metrics interface, implementations must be safe for concurrent use
+type Metrics interface {
+	// Increase counter by delta
+	IncCounter(name string, labels Labels, delta float64)
+
+	// Observe a value in histogram
+	ObserveHistogram(name string, labels Labels, value float64)
+}
+
+// Metrics doing nothing, it is used when metrics are not configured
+var Noop Metrics = noop{}
+
+type noop struct{}
+
+func (noop) IncCounter(name string, labels Labels, delta float64) {}
+
+func (noop) ObserveHistogram(name string, labels Labels, value float64) {}
+
+// Observe the seconds elapsed since start in histogram
+func ObserveSince(m Metrics, name string, labels Labels, start time.Time) {
+	m.ObserveHistogram(name, labels, time.Since(start).Seconds())
+}
@@ -0,0 +1,136 @@
+package prometheus
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/ofavor/ddd-go/pkg/log"
+	"github.com/ofavor/ddd-go/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus metrics option
+type Option func(m *promMetrics)
+
+// Set buckets of histogram, prometheus.DefBuckets is used by default
+func WithBuckets(name string, buckets []float64) Option {
+	return func(m *promMetrics) {
+		m.buckets[name] = buckets
+	}
+}
+
+// promMetrics prometheus adapter, collectors are created and registered on first use.
+// Label names are taken from the first call of each metric.
+type promMetrics struct {
+	namespace  string
+	registerer prometheus.Registerer
+	buckets    map[string][]float64
+	counters   map[string]*prometheus.CounterVec
+	histograms map[string]*prometheus.HistogramVec
+	lock       sync.RWMutex
+}
+
+// NewMetrics create prometheus metrics, names are prefixed with namespace if it is not empty
+func NewMetrics(namespace string, registerer prometheus.Registerer, opts ...Option) metrics.Metrics {
+	m := &promMetrics{
+		namespace:  namespace,
+		registerer: registerer,
+		buckets:    map[string][]float64{},
+		counters:   map[string]*prometheus.CounterVec{},
+		histograms: map[string]*prometheus.HistogramVec{},
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+func labelNames(labels metrics.Labels) []string {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Register collector, the registered one is reused if it already exists
+func (m *promMetrics) register(c prometheus.Collector) prometheus.Collector {
+	if err := m.registerer.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+		log.Warnf("[metrics-prometheus] register collector failed: %v", err)
+	}
+	return c
+}
+
+func (m *promMetrics) counter(name string, labels metrics.Labels) *prometheus.CounterVec {
+	m.lock.RLock()
+	c, ok := m.counters[name]
+	m.lock.RUnlock()
+	if ok {
+		return c
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if c, ok := m.counters[name]; ok {
+		return c
+	}
+	c = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.namespace,
+		Name:      name,
+		Help:      name,
+	}, labelNames(labels))
+	c = m.register(c).(*prometheus.CounterVec)
+	m.counters[name] = c
+	return c
+}
+
+func (m *promMetrics) histogram(name string, labels metrics.Labels) *prometheus.HistogramVec {
+	m.lock.RLock()
+	h, ok := m.histograms[name]
+	m.lock.RUnlock()
+	if ok {
+		return h
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if h, ok := m.histograms[name]; ok {
+		return h
+	}
+	buckets, ok := m.buckets[name]
+	if !ok {
+		buckets = prometheus.DefBuckets
+	}
+	h = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: m.namespace,
+		Name:      name,
+		Help:      name,
+		Buckets:   buckets,
+	}, labelNames(labels))
+	h = m.register(h).(*prometheus.HistogramVec)
+	m.histograms[name] = h
+	return h
+}
+
+// IncCounter implements metrics.Metrics.
+func (m *promMetrics) IncCounter(name string, labels metrics.Labels, delta float64) {
+	c, err := m.counter(name, labels).GetMetricWith(prometheus.Labels(labels))
+	if err != nil {
+		log.Warnf("[metrics-prometheus] counter (%s) failed: %v", name, err)
+		return
+	}
+	c.Add(delta)
+}
+
+// ObserveHistogram implements metrics.Metrics.
+func (m *promMetrics) ObserveHistogram(name string, labels metrics.Labels, value float64) {
+	h, err := m.histogram(name, labels).GetMetricWith(prometheus.Labels(labels))
+	if err != nil {
+		log.Warnf("[metrics-prometheus] histogram (%s) failed: %v", name, err)
+		return
+	}
+	h.Observe(value)
+}
@@ -0,0 +1,63 @@
+package prometheus
+
+import (
+	"testing"
+
+	"github.com/ofavor/ddd-go/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCounter(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := NewMetrics("app", reg)
+	m.IncCounter("requests_total", metrics.Labels{"op": "get"}, 1)
+	m.IncCounter("requests_total", metrics.Labels{"op": "get"}, 2)
+	m.IncCounter("requests_total", metrics.Labels{"op": "set"}, 1)
+
+	c := m.(*promMetrics).counters["requests_total"]
+	if v := testutil.ToFloat64(c.WithLabelValues("get")); v != 3 {
+		t.Errorf("3 expected but got %v", v)
+	}
+	if n := testutil.CollectAndCount(c, "app_requests_total"); n != 2 {
+		t.Errorf("2 series expected but got %d", n)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := NewMetrics("app", reg, WithBuckets("payload_bytes", []float64{10, 100}))
+	m.ObserveHistogram("payload_bytes", metrics.Labels{"op": "get"}, 50)
+	m.ObserveHistogram("payload_bytes", metrics.Labels{"op": "get"}, 500)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("no error expected for gather but got '%v'", err)
+	}
+	if len(families) != 1 || families[0].GetName() != "app_payload_bytes" {
+		t.Fatalf("app_payload_bytes expected but got %v", families)
+	}
+	h := families[0].GetMetric()[0].GetHistogram()
+	if h.GetSampleCount() != 2 || len(h.GetBucket()) != 2 {
+		t.Errorf("2 samples in 2 buckets expected but got %v", h)
+	}
+}
+
+func TestSharedRegistry(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m1 := NewMetrics("app", reg)
+	m2 := NewMetrics("app", reg)
+	m1.IncCounter("requests_total", metrics.Labels{"op": "get"}, 1)
+	m2.IncCounter("requests_total", metrics.Labels{"op": "get"}, 1)
+	if v := testutil.ToFloat64(m1.(*promMetrics).counters["requests_total"]); v != 2 {
+		t.Errorf("collector expected to be shared with 2 but got %v", v)
+	}
+}
+
+func TestInconsistentLabels(t *testing.T) {
+	m := NewMetrics("app", prometheus.NewRegistry())
+	m.IncCounter("requests_total", metrics.Labels{"op": "get"}, 1)
+	// ignored with a warning instead of panicking
+	m.IncCounter("requests_total", metrics.Labels{"result": "ok"}, 1)
+}
@@ -0,0 +1,204 @@
+package gorm
+
+import (
+	"context"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/log"
+	"github.com/ofavor/ddd-go/pkg/mutex"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Locker table model, a row is a held lock.
+// ExpireAt is the unix milliseconds of the database clock, zero means never expire.
+type Locker struct {
+	Name     string `gorm:"primaryKey;type:varchar(191)"`
+	Owner    string `gorm:"type:varchar(191);not null;default:''"`
+	Holds    int    `gorm:"not null;default:1"`
+	ExpireAt int64  `gorm:"not null;default:0;index"`
+}
+
+func (l *Locker) TableName() string {
+	return "mutex_lockers"
+}
+
+// SQL expressions of the database clock in unix milliseconds
+var clockExprs = map[string]string{
+	"mysql":    "SELECT CAST(UNIX_TIMESTAMP(NOW(3)) * 1000 AS SIGNED)",
+	"postgres": "SELECT CAST(EXTRACT(EPOCH FROM clock_timestamp()) * 1000 AS BIGINT)",
+	"sqlite":   "SELECT CAST((julianday('now') - 2440587.5) * 86400000 AS INTEGER)",
+}
+
+// gormMutex mutex implementation based on a database table,
+// expiration is decided by the database clock like redis does
+type gormMutex struct {
+	conn      *gorm.DB
+	clockExpr string
+}
+
+// Create gorm mutex, the locker table is migrated automatically
+func NewMutex(conn *gorm.DB) mutex.ReentrantMutex {
+	if err := conn.AutoMigrate(&Locker{}); err != nil {
+		panic(err)
+	}
+	return NewMutexWithoutMigration(conn)
+}
+
+// Create gorm mutex, the locker table should be registered by user
+func NewMutexWithoutMigration(conn *gorm.DB) mutex.ReentrantMutex {
+	expr, ok := clockExprs[conn.Dialector.Name()]
+	if !ok {
+		panic("Unsupported database driver for mutex: " + conn.Dialector.Name())
+	}
+	return &gormMutex{
+		conn:      conn,
+		clockExpr: expr,
+	}
+}
+
+// Get current time of the database clock in unix milliseconds
+func (m *gormMutex) now(conn *gorm.DB) (now int64, err error) {
+	err = conn.Raw(m.clockExpr).Scan(&now).Error
+	return
+}
+
+func (m *gormMutex) lock(ctx context.Context, owner string, key string, expiration time.Duration) error {
+	conn := m.conn.WithContext(ctx)
+	now, err := m.now(conn)
+	if err != nil {
+		log.Errorf("[mutex-gorm] Got error while trying to lock key '%s': %v", key, err)
+		return err
+	}
+	var expireAt int64
+	if expiration > 0 { // same as redis, zero expiration means never expire
+		expireAt = now + expiration.Milliseconds()
+	}
+	// drop the expired locker
+	if err := conn.Where("name = ? AND expire_at > 0 AND expire_at <= ?", key, now).
+		Delete(&Locker{}).Error; err != nil {
+		log.Errorf("[mutex-gorm] Got error while trying to lock key '%s': %v", key, err)
+		return err
+	}
+	if owner != "" {
+		var expire interface{} = int64(0)
+		if expireAt > 0 { // never shorten the lease of outer holds
+			expire = gorm.Expr("CASE WHEN expire_at = 0 OR expire_at > ? THEN expire_at ELSE ? END", expireAt, expireAt)
+		}
+		r := conn.Model(&Locker{}).Where("name = ? AND owner = ?", key, owner).Updates(map[string]interface{}{
+			"holds":     gorm.Expr("holds + 1"),
+			"expire_at": expire,
+		})
+		if r.Error != nil {
+			log.Errorf("[mutex-gorm] Got error while trying to lock key '%s' for '%s': %v", key, owner, r.Error)
+			return r.Error
+		}
+		if r.RowsAffected > 0 {
+			return nil
+		}
+	}
+	r := conn.Clauses(clause.OnConflict{DoNothing: true}).Create(&Locker{
+		Name:     key,
+		Owner:    owner,
+		Holds:    1,
+		ExpireAt: expireAt,
+	})
+	if r.Error != nil {
+		log.Errorf("[mutex-gorm] Got error while trying to lock key '%s': %v", key, r.Error)
+		return r.Error
+	}
+	if r.RowsAffected == 0 {
+		log.Errorf("[mutex-gorm] Lock key '%s' failed", key)
+		return mutex.ErrFail
+	}
+	return nil
+}
+
+func (m *gormMutex) Lock(key string, expiration time.Duration) error {
+	return m.lock(context.Background(), "", key, expiration)
+}
+
+func (m *gormMutex) Unlock(key string) error {
+	if err := m.conn.Where("name = ?", key).Delete(&Locker{}).Error; err != nil {
+		log.Warnf("[mutex-gorm] Got error while trying to unlock key '%s': %v", key, err)
+	}
+	return nil
+}
+
+// LockContext implements mutex.ReentrantMutex.
+func (m *gormMutex) LockContext(ctx context.Context, key string, expiration time.Duration) error {
+	return m.lock(ctx, mutex.Owner(ctx), key, expiration)
+}
+
+// UnlockContext implements mutex.ReentrantMutex.
+func (m *gormMutex) UnlockContext(ctx context.Context, key string) error {
+	owner := mutex.Owner(ctx)
+	if owner == "" {
+		return m.Unlock(key)
+	}
+	conn := m.conn.WithContext(ctx)
+	now, err := m.now(conn)
+	if err != nil {
+		log.Errorf("[mutex-gorm] Got error while trying to unlock key '%s' for '%s': %v", key, owner, err)
+		return err
+	}
+	r := conn.Model(&Locker{}).
+		Where("name = ? AND owner = ? AND holds > 1 AND (expire_at = 0 OR expire_at > ?)", key, owner, now).
+		Update("holds", gorm.Expr("holds - 1"))
+	if r.Error != nil {
+		log.Errorf("[mutex-gorm] Got error while trying to unlock key '%s' for '%s': %v", key, owner, r.Error)
+		return r.Error
+	}
+	if r.RowsAffected > 0 {
+		return nil
+	}
+	r = conn.Where("name = ? AND owner = ? AND (expire_at = 0 OR expire_at > ?)", key, owner, now).Delete(&Locker{})
+	if r.Error != nil {
+		log.Errorf("[mutex-gorm] Got error while trying to unlock key '%s' for '%s': %v", key, owner, r.Error)
+		return r.Error
+	}
+	if r.RowsAffected == 0 {
+		return mutex.ErrNotHeld
+	}
+	return nil
+}
+
+// RenewContext implements mutex.ReentrantMutex.
+func (m *gormMutex) RenewContext(ctx context.Context, key string, expiration time.Duration) error {
+	owner := mutex.Owner(ctx)
+	if owner == "" {
+		return mutex.ErrNotHeld
+	}
+	conn := m.conn.WithContext(ctx)
+	now, err := m.now(conn)
+	if err != nil {
+		log.Errorf("[mutex-gorm] Got error while trying to renew key '%s' for '%s': %v", key, owner, err)
+		return err
+	}
+	var expireAt int64
+	if expiration > 0 {
+		expireAt = now + expiration.Milliseconds()
+	}
+	held := func(db *gorm.DB) *gorm.DB {
+		return db.Model(&Locker{}).Where("name = ? AND owner = ? AND (expire_at = 0 OR expire_at > ?)", key, owner, now)
+	}
+	r := held(conn).Update("expire_at", expireAt)
+	if r.Error != nil {
+		log.Errorf("[mutex-gorm] Got error while trying to renew key '%s' for '%s': %v", key, owner, r.Error)
+		return r.Error
+	}
+	if r.RowsAffected > 0 {
+		return nil
+	}
+	// mysql reports no affected rows if the value is not changed
+	var cnt int64
+	if err := held(conn).Count(&cnt).Error; err != nil {
+		log.Errorf("[mutex-gorm] Got error while trying to renew key '%s' for '%s': %v", key, owner, err)
+		return err
+	}
+	if cnt == 0 {
+		return mutex.ErrNotHeld
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package gorm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/mutex"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestMutex(t *testing.T) *gormMutex {
+	conn, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, _ := conn.DB()
+	db.SetMaxOpenConns(1) // every connection has its own memory database
+	t.Cleanup(func() { db.Close() })
+	return NewMutex(conn).(*gormMutex)
+}
+
+func TestLockSuccess(t *testing.T) {
+	m := newTestMutex(t)
+	key := "key.test"
+	err := m.Lock(key, 0)
+	if err != nil {
+		t.Errorf("no error expected for Lock but got '%v'", err)
+	}
+}
+
+func TestLockFailed(t *testing.T) {
+	m := newTestMutex(t)
+	key := "key.test"
+	err := m.Lock(key, time.Second)
+	if err != nil {
+		t.Error("no error expected for Lock")
+	}
+	err = m.Lock(key, time.Second)
+	if err != mutex.ErrFail {
+		t.Errorf("expected error 'lock failed' but got '%v'", err)
+	}
+}
+
+func TestLockExpired(t *testing.T) {
+	m := newTestMutex(t)
+	key := "key.test"
+	err := m.Lock(key, 50*time.Millisecond)
+	if err != nil {
+		t.Error("no error expected for Lock")
+	}
+	time.Sleep(100 * time.Millisecond)
+	err = m.Lock(key, time.Second)
+	if err != nil {
+		t.Errorf("no error expected for Lock on expired key but got '%v'", err)
+	}
+}
+
+func TestLockNeverExpire(t *testing.T) {
+	m := newTestMutex(t)
+	key := "key.test"
+	err := m.Lock(key, 0)
+	if err != nil {
+		t.Error("no error expected for Lock")
+	}
+	err = m.Lock(key, 0)
+	if err != mutex.ErrFail {
+		t.Errorf("expected error 'lock failed' but got '%v'", err)
+	}
+}
+
+func TestUnlock(t *testing.T) {
+	m := newTestMutex(t)
+	key := "key.test"
+	err := m.Lock(key, time.Second)
+	if err != nil {
+		t.Error("no error expected for Lock")
+	}
+	err = m.Unlock(key)
+	if err != nil {
+		t.Error("no error expected for Unlock")
+	}
+	var cnt int64
+	m.conn.Model(&Locker{}).Count(&cnt)
+	if cnt != 0 {
+		t.Error("lockers should be empty")
+	}
+}
+
+func TestReentrantLock(t *testing.T) {
+	m := newTestMutex(t)
+	key := "key.test"
+	ctx := mutex.WithOwner(context.Background(), "owner1")
+	if err := m.LockContext(ctx, key, time.Second); err != nil {
+		t.Errorf("no error expected for LockContext but got '%v'", err)
+	}
+	if err := m.LockContext(ctx, key, time.Second); err != nil {
+		t.Errorf("no error expected for reentrant LockContext but got '%v'", err)
+	}
+	other := mutex.WithOwner(context.Background(), "owner2")
+	if err := m.LockContext(other, key, time.Second); err != mutex.ErrFail {
+		t.Errorf("expected error 'lock failed' but got '%v'", err)
+	}
+	if err := m.UnlockContext(other, key); err != mutex.ErrNotHeld {
+		t.Errorf("expected error 'lock not held' but got '%v'", err)
+	}
+	if err := m.UnlockContext(ctx, key); err != nil {
+		t.Errorf("no error expected for UnlockContext but got '%v'", err)
+	}
+	if err := m.Lock(key, time.Second); err != mutex.ErrFail {
+		t.Error("lock should be held until the final UnlockContext")
+	}
+	if err := m.UnlockContext(ctx, key); err != nil {
+		t.Errorf("no error expected for UnlockContext but got '%v'", err)
+	}
+	if err := m.UnlockContext(ctx, key); err != mutex.ErrNotHeld {
+		t.Errorf("expected error 'lock not held' but got '%v'", err)
+	}
+	if err := m.Lock(key, time.Second); err != nil {
+		t.Errorf("no error expected for Lock after release but got '%v'", err)
+	}
+}
+
+func TestReentrantLockExpiration(t *testing.T) {
+	m := newTestMutex(t)
+	key := "key.test"
+	ctx := mutex.WithOwner(context.Background(), "owner1")
+	m.LockContext(ctx, key, time.Minute)
+	m.LockContext(ctx, key, 50*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	if err := m.Lock(key, time.Second); err != mutex.ErrFail {
+		t.Errorf("nested lock should not shorten expiration but got '%v'", err)
+	}
+}
+
+func TestDatabaseClock(t *testing.T) {
+	m := newTestMutex(t)
+	now, err := m.now(m.conn)
+	if err != nil {
+		t.Fatalf("no error expected for reading database clock but got '%v'", err)
+	}
+	if d := time.Since(time.UnixMilli(now)); d > time.Second || d < -time.Second {
+		t.Errorf("database clock is off by %s", d)
+	}
+}
+
+func TestRenew(t *testing.T) {
+	m := newTestMutex(t)
+	key := "key.test"
+	ctx := mutex.WithOwner(context.Background(), "owner1")
+	if err := m.RenewContext(ctx, key, time.Second); err != mutex.ErrNotHeld {
+		t.Errorf("expected error 'lock not held' but got '%v'", err)
+	}
+	m.LockContext(ctx, key, 50*time.Millisecond)
+	if err := m.RenewContext(ctx, key, time.Minute); err != nil {
+		t.Errorf("no error expected for RenewContext but got '%v'", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := m.Lock(key, time.Second); err != mutex.ErrFail {
+		t.Errorf("lock should be renewed but got '%v'", err)
+	}
+	if err := m.RenewContext(ctx, key, 0); err != nil {
+		t.Errorf("no error expected for RenewContext but got '%v'", err)
+	}
+	if err := m.UnlockContext(ctx, key); err != nil {
+		t.Errorf("hold count should not be changed by renewal but got '%v'", err)
+	}
+	if err := m.UnlockContext(ctx, key); err != mutex.ErrNotHeld {
+		t.Errorf("expected error 'lock not held' but got '%v'", err)
+	}
+}
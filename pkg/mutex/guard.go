@@ -0,0 +1,102 @@
+package mutex
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/cache"
+	"github.com/ofavor/ddd-go/pkg/log"
+	"github.com/ofavor/ddd-go/pkg/tx"
+)
+
+// Lock contention error, the key is held by others.
+// errors.Is(err, ErrFail) reports true for it.
+type ContentionError struct {
+	Key string
+}
+
+func (e *ContentionError) Error() string {
+	return fmt.Sprintf("lock '%s' is held by others", e.Key)
+}
+
+func (e *ContentionError) Unwrap() error {
+	return ErrFail
+}
+
+func lock(ctx context.Context, m Mutex, key string, ttl time.Duration) error {
+	var err error
+	if rm, ok := m.(ReentrantMutex); ok {
+		err = rm.LockContext(ctx, key, ttl)
+	} else {
+		err = m.Lock(key, ttl)
+	}
+	if err == ErrFail {
+		return &ContentionError{Key: key}
+	}
+	return err
+}
+
+func unlock(ctx context.Context, m Mutex, key string) {
+	var err error
+	if rm, ok := m.(ReentrantMutex); ok {
+		err = rm.UnlockContext(ctx, key)
+	} else {
+		err = m.Unlock(key)
+	}
+	if err != nil {
+		log.Warnf("[mutex] Got error while unlocking key '%s': %v", key, err)
+	}
+}
+
+// Run fn with the key locked, the lock is always released even if fn panics.
+// ContentionError is returned if the key is held by others.
+// The owner in context is used if m is a ReentrantMutex, so nested calls of the same owner do not deadlock.
+func WithLock(ctx context.Context, m Mutex, key string, ttl time.Duration, fn func(ctx context.Context) error) error {
+	if err := lock(ctx, m, key, ttl); err != nil {
+		return err
+	}
+	defer unlock(ctx, m, key)
+	return fn(ctx)
+}
+
+// Run a transaction with the key locked, the lock is released after the transaction is finished
+func WithLockTx(ctx context.Context, m Mutex, tm tx.TransMgr, key string, ttl time.Duration, fn tx.TransFunc) error {
+	return WithLock(ctx, m, key, ttl, func(ctx context.Context) error {
+		return tm.Transaction(fn)
+	})
+}
+
+// Run fn at most once for an idempotency key, the result is stored in cache for resultTTL,
+// so that retried commands get the first result. Failed runs are not stored and can be retried.
+// ContentionError is returned if the same command is running.
+func WithIdempotency[T any](
+	ctx context.Context,
+	m Mutex,
+	c cache.Cache,
+	key string,
+	ttl time.Duration,
+	resultTTL time.Duration,
+	fn func(ctx context.Context) (T, error),
+) (out T, err error) {
+	ckey := fmt.Sprintf("__idempotency__:%s", key)
+	if err = c.Get(ctx, ckey, &out); err != cache.ErrNil {
+		return
+	}
+	err = WithLock(ctx, m, ckey, ttl, func(ctx context.Context) error {
+		// check again, the command might be finished while waiting for the lock
+		if err := c.Get(ctx, ckey, &out); err != cache.ErrNil {
+			return err
+		}
+		r, err := fn(ctx)
+		if err != nil {
+			return err
+		}
+		out = r
+		if err := c.Set(ctx, ckey, r, resultTTL); err != nil {
+			log.Warnf("[mutex] Got error while storing result of idempotency key '%s': %v", key, err)
+		}
+		return nil
+	})
+	return
+}
@@ -0,0 +1,156 @@
+package mutex_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/cache"
+	"github.com/ofavor/ddd-go/pkg/mutex"
+	"github.com/ofavor/ddd-go/pkg/mutex/local"
+	"github.com/ofavor/ddd-go/pkg/tx"
+)
+
+type mapCache map[string][]byte
+
+func (c mapCache) GetConn() interface{} {
+	return nil
+}
+
+func (c mapCache) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	j, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	c[key] = j
+	return nil
+}
+
+func (c mapCache) Get(ctx context.Context, key string, out interface{}) error {
+	j, ok := c[key]
+	if !ok {
+		return cache.ErrNil
+	}
+	return json.Unmarshal(j, out)
+}
+
+func (c mapCache) Del(ctx context.Context, key ...string) error {
+	for _, k := range key {
+		delete(c, k)
+	}
+	return nil
+}
+
+type dummyTransMgr struct {
+	count int
+}
+
+func (tm *dummyTransMgr) Transaction(f tx.TransFunc) error {
+	tm.count++
+	return f(nil)
+}
+
+func TestWithLock(t *testing.T) {
+	m := local.NewMutex()
+	ctx := context.Background()
+	key := "key.test"
+	called := false
+	err := mutex.WithLock(ctx, m, key, time.Second, func(ctx context.Context) error {
+		called = true
+		err := mutex.WithLock(ctx, m, key, time.Second, func(ctx context.Context) error { return nil })
+		var ce *mutex.ContentionError
+		if !errors.As(err, &ce) || !errors.Is(err, mutex.ErrFail) {
+			t.Errorf("expected contention error but got '%v'", err)
+		}
+		return nil
+	})
+	if err != nil || !called {
+		t.Errorf("no error expected for WithLock but got '%v'", err)
+	}
+	if err := m.Lock(key, time.Second); err != nil {
+		t.Error("lock should be released after WithLock")
+	}
+}
+
+func TestWithLockReentrant(t *testing.T) {
+	m := local.NewMutex()
+	ctx := mutex.WithOwner(context.Background(), "owner1")
+	key := "key.test"
+	err := mutex.WithLock(ctx, m, key, time.Second, func(ctx context.Context) error {
+		return mutex.WithLock(ctx, m, key, time.Second, func(ctx context.Context) error { return nil })
+	})
+	if err != nil {
+		t.Errorf("no error expected for nested WithLock of the same owner but got '%v'", err)
+	}
+	if err := m.Lock(key, time.Second); err != nil {
+		t.Error("lock should be released after WithLock")
+	}
+}
+
+func TestWithLockPanic(t *testing.T) {
+	m := local.NewMutex()
+	key := "key.test"
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("panic should be propagated")
+			}
+		}()
+		mutex.WithLock(context.Background(), m, key, time.Second, func(ctx context.Context) error {
+			panic("some panic")
+		})
+	}()
+	if err := m.Lock(key, time.Second); err != nil {
+		t.Error("lock should be released after panic")
+	}
+}
+
+func TestWithLockTx(t *testing.T) {
+	m := local.NewMutex()
+	tm := &dummyTransMgr{}
+	err := mutex.WithLockTx(context.Background(), m, tm, "key.test", time.Second, func(tx tx.Trans) error {
+		return errors.New("some error")
+	})
+	if err == nil || err.Error() != "some error" {
+		t.Errorf("expected error 'some error' but got '%v'", err)
+	}
+	if tm.count != 1 {
+		t.Error("transaction should be started")
+	}
+}
+
+func TestWithIdempotency(t *testing.T) {
+	m := local.NewMutex()
+	c := mapCache{}
+	ctx := context.Background()
+	count := 0
+	fn := func(ctx context.Context) (int, error) {
+		count++
+		return 100 + count, nil
+	}
+	r, err := mutex.WithIdempotency(ctx, m, c, "cmd.1", time.Second, time.Minute, fn)
+	if err != nil || r != 101 {
+		t.Errorf("expected result 101 but got %d, '%v'", r, err)
+	}
+	r, err = mutex.WithIdempotency(ctx, m, c, "cmd.1", time.Second, time.Minute, fn)
+	if err != nil || r != 101 {
+		t.Errorf("expected first result 101 for retry but got %d, '%v'", r, err)
+	}
+	if count != 1 {
+		t.Errorf("command should run once but ran %d times", count)
+	}
+
+	failed := func(ctx context.Context) (int, error) {
+		count++
+		return 0, errors.New("some error")
+	}
+	if _, err := mutex.WithIdempotency(ctx, m, c, "cmd.2", time.Second, time.Minute, failed); err == nil {
+		t.Error("error expected for failed command")
+	}
+	r, err = mutex.WithIdempotency(ctx, m, c, "cmd.2", time.Second, time.Minute, fn)
+	if err != nil || r != 103 {
+		t.Errorf("failed command should be retried but got %d, '%v'", r, err)
+	}
+}
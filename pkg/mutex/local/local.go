@@ -1,43 +1,218 @@
 package local
 
 import (
+	"context"
 	"sync"
 	"time"
 
 	"github.com/ofavor/ddd-go/pkg/mutex"
 )
 
+// Minimum number of lockers to trigger the amortized eviction
+const minSweepSize = 64
+
+// Local mutex option
+type Option func(m *localMutex)
+
+// Start a janitor to evict expired lockers periodically in background, stop it by Close
+func WithJanitor(interval time.Duration) Option {
+	return func(m *localMutex) {
+		m.janitorInterval = interval
+	}
+}
+
+// Local mutex statistics
+type Stats struct {
+	// Number of lockers being held
+	Held int
+	// Number of expired lockers not evicted yet
+	Expired int
+}
+
+// Local mutex interface
+type Mutex interface {
+	mutex.ReentrantMutex
+
+	// Get statistics of lockers
+	Stats() Stats
+
+	// Stop the janitor
+	Close() error
+}
+
+type locker struct {
+	owner    string
+	count    int
+	expireAt time.Time
+}
+
+func (l *locker) expired(now time.Time) bool {
+	return !l.expireAt.After(now)
+}
+
 type localMutex struct {
-	lockers map[string]time.Time
-	mutex   *sync.Mutex
+	lockers         map[string]*locker
+	mutex           *sync.Mutex
+	sweepSize       int
+	janitorInterval time.Duration
+	stop            chan struct{}
+	done            chan struct{}
 }
 
-func newLocalMutex() *localMutex {
-	return &localMutex{
-		lockers: make(map[string]time.Time),
-		mutex:   new(sync.Mutex),
+func newLocalMutex(opts ...Option) *localMutex {
+	m := &localMutex{
+		lockers:   make(map[string]*locker),
+		mutex:     new(sync.Mutex),
+		sweepSize: minSweepSize,
+	}
+	for _, opt := range opts {
+		opt(m)
 	}
+	if m.janitorInterval > 0 {
+		m.stop = make(chan struct{})
+		m.done = make(chan struct{})
+		go m.janitor(m.stop, m.done)
+	}
+	return m
 }
 
-func NewMutex() mutex.Mutex {
-	return newLocalMutex()
+func NewMutex(opts ...Option) Mutex {
+	return newLocalMutex(opts...)
 }
 
-func (m *localMutex) Lock(key string, expiration time.Duration) error {
+func (m *localMutex) janitor(stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(m.janitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.mutex.Lock()
+			m.evict(time.Now())
+			m.mutex.Unlock()
+		}
+	}
+}
+
+// Evict expired lockers, must be called with the mutex held
+func (m *localMutex) evict(now time.Time) {
+	for k, l := range m.lockers {
+		if l.expired(now) {
+			delete(m.lockers, k)
+		}
+	}
+	// the map is swept again only when it doubles, so the cost is amortized across locks
+	m.sweepSize = 2 * len(m.lockers)
+	if m.sweepSize < minSweepSize {
+		m.sweepSize = minSweepSize
+	}
+}
+
+func (m *localMutex) lock(owner string, key string, expiration time.Duration) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
-	if v, ok := m.lockers[key]; ok {
-		if v.After(time.Now()) {
+	now := time.Now()
+	if l, ok := m.lockers[key]; ok && !l.expired(now) {
+		if owner == "" || l.owner != owner {
 			return mutex.ErrFail
 		}
+		l.count++
+		if expireAt := now.Add(expiration); expireAt.After(l.expireAt) { // never shorten the lease of outer holds
+			l.expireAt = expireAt
+		}
+		return nil
+	}
+	if len(m.lockers) >= m.sweepSize {
+		m.evict(now)
+	}
+	m.lockers[key] = &locker{
+		owner:    owner,
+		count:    1,
+		expireAt: now.Add(expiration),
 	}
-	m.lockers[key] = time.Now().Add(expiration)
 	return nil
 }
 
+func (m *localMutex) Lock(key string, expiration time.Duration) error {
+	return m.lock("", key, expiration)
+}
+
 func (m *localMutex) Unlock(key string) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	delete(m.lockers, key)
 	return nil
 }
+
+// LockContext implements mutex.ReentrantMutex.
+func (m *localMutex) LockContext(ctx context.Context, key string, expiration time.Duration) error {
+	return m.lock(mutex.Owner(ctx), key, expiration)
+}
+
+// UnlockContext implements mutex.ReentrantMutex.
+func (m *localMutex) UnlockContext(ctx context.Context, key string) error {
+	owner := mutex.Owner(ctx)
+	if owner == "" {
+		return m.Unlock(key)
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	l, ok := m.lockers[key]
+	if !ok || l.owner != owner {
+		return mutex.ErrNotHeld
+	}
+	if l.expired(time.Now()) {
+		delete(m.lockers, key)
+		return mutex.ErrNotHeld
+	}
+	l.count--
+	if l.count <= 0 {
+		delete(m.lockers, key)
+	}
+	return nil
+}
+
+// RenewContext implements mutex.ReentrantMutex.
+func (m *localMutex) RenewContext(ctx context.Context, key string, expiration time.Duration) error {
+	owner := mutex.Owner(ctx)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	l, ok := m.lockers[key]
+	if !ok || owner == "" || l.owner != owner || l.expired(now) {
+		return mutex.ErrNotHeld
+	}
+	l.expireAt = now.Add(expiration)
+	return nil
+}
+
+// Stats implements Mutex.
+func (m *localMutex) Stats() Stats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	s := Stats{}
+	for _, l := range m.lockers {
+		if l.expired(now) {
+			s.Expired++
+		} else {
+			s.Held++
+		}
+	}
+	return s
+}
+
+// Close implements Mutex.
+func (m *localMutex) Close() error {
+	m.mutex.Lock()
+	stop, done := m.stop, m.done
+	m.stop, m.done = nil, nil
+	m.mutex.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	return nil
+}
@@ -1,8 +1,12 @@
 package local
 
 import (
+	"context"
+	"fmt"
 	"testing"
 	"time"
+
+	"github.com/ofavor/ddd-go/pkg/mutex"
 )
 
 func TestLockSuccess(t *testing.T) {
@@ -45,3 +49,141 @@ func TestUnlock(t *testing.T) {
 		t.Error("lockers should be empty")
 	}
 }
+
+func TestReentrantLock(t *testing.T) {
+	m := newLocalMutex()
+	key := "key.test"
+	ctx := mutex.WithOwner(context.Background(), "owner1")
+	if err := m.LockContext(ctx, key, time.Second); err != nil {
+		t.Error("no error expected for LockContext")
+	}
+	if err := m.LockContext(ctx, key, time.Second); err != nil {
+		t.Error("no error expected for reentrant LockContext")
+	}
+	if m.lockers[key].count != 2 {
+		t.Errorf("expected hold count 2 but got %d", m.lockers[key].count)
+	}
+	other := mutex.WithOwner(context.Background(), "owner2")
+	if err := m.LockContext(other, key, time.Second); err != mutex.ErrFail {
+		t.Errorf("expected error 'lock failed' but got '%v'", err)
+	}
+	if err := m.Lock(key, time.Second); err != mutex.ErrFail {
+		t.Errorf("expected error 'lock failed' but got '%v'", err)
+	}
+	if err := m.UnlockContext(other, key); err != mutex.ErrNotHeld {
+		t.Errorf("expected error 'lock not held' but got '%v'", err)
+	}
+	if err := m.UnlockContext(ctx, key); err != nil {
+		t.Error("no error expected for UnlockContext")
+	}
+	if len(m.lockers) != 1 {
+		t.Error("lock should be held until the final UnlockContext")
+	}
+	if err := m.UnlockContext(ctx, key); err != nil {
+		t.Error("no error expected for UnlockContext")
+	}
+	if len(m.lockers) != 0 {
+		t.Error("lockers should be empty")
+	}
+	if err := m.UnlockContext(ctx, key); err != mutex.ErrNotHeld {
+		t.Errorf("expected error 'lock not held' but got '%v'", err)
+	}
+}
+
+func TestReentrantLockExpiration(t *testing.T) {
+	m := newLocalMutex()
+	key := "key.test"
+	ctx := mutex.WithOwner(context.Background(), "owner1")
+	m.LockContext(ctx, key, time.Minute)
+	expireAt := m.lockers[key].expireAt
+	m.LockContext(ctx, key, time.Millisecond)
+	if m.lockers[key].expireAt != expireAt {
+		t.Error("nested lock should not shorten expiration")
+	}
+	m.LockContext(ctx, key, time.Hour)
+	if !m.lockers[key].expireAt.After(expireAt) {
+		t.Error("nested lock should extend expiration")
+	}
+}
+
+func TestReentrantLockWithoutOwner(t *testing.T) {
+	m := newLocalMutex()
+	key := "key.test"
+	ctx := context.Background()
+	if err := m.LockContext(ctx, key, time.Second); err != nil {
+		t.Error("no error expected for LockContext")
+	}
+	if err := m.LockContext(ctx, key, time.Second); err != mutex.ErrFail {
+		t.Errorf("expected error 'lock failed' but got '%v'", err)
+	}
+	if err := m.UnlockContext(ctx, key); err != nil {
+		t.Error("no error expected for UnlockContext")
+	}
+	if len(m.lockers) != 0 {
+		t.Error("lockers should be empty")
+	}
+}
+
+func TestStats(t *testing.T) {
+	m := newLocalMutex()
+	m.Lock("key.held", time.Minute)
+	m.Lock("key.expired", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	s := m.Stats()
+	if s.Held != 1 || s.Expired != 1 {
+		t.Errorf("expected 1 held and 1 expired but got %d and %d", s.Held, s.Expired)
+	}
+}
+
+func TestAmortizedEviction(t *testing.T) {
+	m := newLocalMutex()
+	for i := 0; i < 10*minSweepSize; i++ {
+		m.Lock(fmt.Sprintf("key.%d", i), 0)
+	}
+	if len(m.lockers) > minSweepSize {
+		t.Errorf("expired lockers should be evicted but got %d lockers", len(m.lockers))
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	m := newLocalMutex(WithJanitor(10 * time.Millisecond))
+	m.Lock("key.held", time.Minute)
+	m.Lock("key.expired", time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	s := m.Stats()
+	if s.Held != 1 || s.Expired != 0 {
+		t.Errorf("expected 1 held and 0 expired but got %d and %d", s.Held, s.Expired)
+	}
+	done := m.done
+	if err := m.Close(); err != nil {
+		t.Error("no error expected for Close")
+	}
+	select {
+	case <-done:
+	default:
+		t.Error("janitor should be stopped after Close")
+	}
+	if err := m.Close(); err != nil {
+		t.Error("no error expected for Close twice")
+	}
+}
+
+func TestRenew(t *testing.T) {
+	m := newLocalMutex()
+	key := "key.test"
+	ctx := mutex.WithOwner(context.Background(), "owner1")
+	if err := m.RenewContext(ctx, key, time.Second); err != mutex.ErrNotHeld {
+		t.Errorf("expected error 'lock not held' but got '%v'", err)
+	}
+	m.LockContext(ctx, key, time.Millisecond)
+	if err := m.RenewContext(ctx, key, time.Minute); err != nil {
+		t.Errorf("no error expected for RenewContext but got '%v'", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if m.lockers[key].expired(time.Now()) || m.lockers[key].count != 1 {
+		t.Error("lock should be renewed with hold count unchanged")
+	}
+	if err := m.RenewContext(mutex.WithOwner(context.Background(), "owner2"), key, time.Minute); err != mutex.ErrNotHeld {
+		t.Errorf("expected error 'lock not held' but got '%v'", err)
+	}
+}
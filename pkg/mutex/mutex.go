@@ -1,12 +1,15 @@
 package mutex
 
 import (
+	"context"
 	"errors"
 	"time"
 )
 
 var ErrFail = errors.New("lock failed")
 
+var ErrNotHeld = errors.New("lock not held")
+
 // Mutex interface
 type Mutex interface {
 	// Lock a specific key with a duration
@@ -15,3 +18,37 @@ type Mutex interface {
 	// Unlock a specific key
 	Unlock(key string) error
 }
+
+// Reentrant mutex interface, the lock owner is carried by the context
+type ReentrantMutex interface {
+	Mutex
+
+	// Lock a specific key for the owner in context, the hold count is increased if the owner already holds it.
+	// A reentrant lock extends the expiration if it is longer than the remaining one, it never shortens it.
+	// It acts as Lock if there is no owner in context.
+	LockContext(ctx context.Context, key string, expiration time.Duration) error
+
+	// Unlock a specific key for the owner in context, the lock is released when the hold count drops to zero.
+	// It acts as Unlock if there is no owner in context.
+	UnlockContext(ctx context.Context, key string) error
+
+	// Renew the expiration of a specific key held by the owner in context, the hold count is not changed.
+	// ErrNotHeld is returned if the owner does not hold it.
+	RenewContext(ctx context.Context, key string, expiration time.Duration) error
+}
+
+type ownerKey struct{}
+
+// Create a context carrying the lock owner identity
+func WithOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, ownerKey{}, owner)
+}
+
+// Get the lock owner identity from context, empty string is returned if not set
+func Owner(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	owner, _ := ctx.Value(ownerKey{}).(string)
+	return owner
+}
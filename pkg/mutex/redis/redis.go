@@ -11,16 +11,73 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+// Reentrant lock is stored as a hash: owner => hold count.
+// A key held by plain Lock is a string, it is treated as held by others.
+var lockScript = redis.NewScript(`
+local t = redis.call('type', KEYS[1]).ok
+if t ~= 'none' and (t ~= 'hash' or redis.call('hexists', KEYS[1], ARGV[1]) == 0) then
+	return 0
+end
+redis.call('hincrby', KEYS[1], ARGV[1], 1)
+local ttl = tonumber(ARGV[2])
+if t == 'none' then
+	if ttl > 0 then
+		redis.call('pexpire', KEYS[1], ttl)
+	end
+elseif ttl <= 0 then
+	redis.call('persist', KEYS[1])
+else
+	local cur = redis.call('pttl', KEYS[1])
+	if cur >= 0 and cur < ttl then
+		redis.call('pexpire', KEYS[1], ttl)
+	end
+end
+return 1
+`)
+
+var unlockScript = redis.NewScript(`
+if redis.call('type', KEYS[1]).ok ~= 'hash' or redis.call('hexists', KEYS[1], ARGV[1]) == 0 then
+	return -1
+end
+local n = redis.call('hincrby', KEYS[1], ARGV[1], -1)
+if n <= 0 then
+	redis.call('del', KEYS[1])
+	return 0
+end
+return n
+`)
+
+var renewScript = redis.NewScript(`
+if redis.call('type', KEYS[1]).ok ~= 'hash' or redis.call('hexists', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+if tonumber(ARGV[2]) > 0 then
+	redis.call('pexpire', KEYS[1], ARGV[2])
+else
+	redis.call('persist', KEYS[1])
+end
+return 1
+`)
+
 type redisMutex struct {
-	conn *redis.Client
+	conn redis.UniversalClient
 }
 
-func NewMutex(conn *redis.Client) mutex.Mutex {
+// NewMutex create redis mutex, cluster and failover clients are supported
+func NewMutex(conn redis.UniversalClient) mutex.ReentrantMutex {
 	return &redisMutex{
 		conn: conn,
 	}
 }
 
+// NewMutexWithOptions create redis mutex with universal options, multiple addrs connect to a cluster,
+// a master name connects to sentinel
+func NewMutexWithOptions(options *redis.UniversalOptions) mutex.ReentrantMutex {
+	log.Debug("[mutex-redis] connect to ", options.Addrs)
+	return NewMutex(redis.NewUniversalClient(options))
+}
+
+// Every command touches a single key, so keys need no hash tag on cluster
 func (m *redisMutex) genKey(key string) string {
 	return fmt.Sprintf("__locker__:%s", key)
 }
@@ -42,3 +99,55 @@ func (m *redisMutex) Unlock(key string) error {
 	m.conn.Del(context.Background(), m.genKey(key))
 	return nil
 }
+
+// LockContext implements mutex.ReentrantMutex.
+func (m *redisMutex) LockContext(ctx context.Context, key string, expiration time.Duration) error {
+	owner := mutex.Owner(ctx)
+	if owner == "" {
+		return m.Lock(key, expiration)
+	}
+	r, err := lockScript.Run(ctx, m.conn, []string{m.genKey(key)}, owner, expiration.Milliseconds()).Int64()
+	if err != nil {
+		log.Errorf("[mutex-redis] Got error while trying to lock key '%s' for '%s': %v", key, owner, err)
+		return err
+	}
+	if r == 0 {
+		log.Errorf("[mutex-redis] Lock key '%s' for '%s' failed", key, owner)
+		return mutex.ErrFail
+	}
+	return nil
+}
+
+// UnlockContext implements mutex.ReentrantMutex.
+func (m *redisMutex) UnlockContext(ctx context.Context, key string) error {
+	owner := mutex.Owner(ctx)
+	if owner == "" {
+		return m.Unlock(key)
+	}
+	r, err := unlockScript.Run(ctx, m.conn, []string{m.genKey(key)}, owner).Int64()
+	if err != nil {
+		log.Errorf("[mutex-redis] Got error while trying to unlock key '%s' for '%s': %v", key, owner, err)
+		return err
+	}
+	if r < 0 {
+		return mutex.ErrNotHeld
+	}
+	return nil
+}
+
+// RenewContext implements mutex.ReentrantMutex.
+func (m *redisMutex) RenewContext(ctx context.Context, key string, expiration time.Duration) error {
+	owner := mutex.Owner(ctx)
+	if owner == "" {
+		return mutex.ErrNotHeld
+	}
+	r, err := renewScript.Run(ctx, m.conn, []string{m.genKey(key)}, owner, expiration.Milliseconds()).Int64()
+	if err != nil {
+		log.Errorf("[mutex-redis] Got error while trying to renew key '%s' for '%s': %v", key, owner, err)
+		return err
+	}
+	if r == 0 {
+		return mutex.ErrNotHeld
+	}
+	return nil
+}
@@ -1,11 +1,16 @@
 package redis
 
 import (
+	"context"
 	"errors"
 	"testing"
+	"time"
 
+	"github.com/ofavor/ddd-go/pkg/mutex"
+
+	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redismock/v9"
-	// "time"
+	"github.com/redis/go-redis/v9"
 )
 
 func TestLockSuccess(t *testing.T) {
@@ -59,3 +64,147 @@ func TestUnlockSuccess(t *testing.T) {
 		t.Error("no error expected for Unlock")
 	}
 }
+
+func newMiniredisMutex(t *testing.T) (*redisMutex, *miniredis.Miniredis) {
+	s := miniredis.RunT(t)
+	conn := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	t.Cleanup(func() { conn.Close() })
+	return &redisMutex{conn: conn}, s
+}
+
+func TestReentrantLock(t *testing.T) {
+	red, s := newMiniredisMutex(t)
+	key := "key.test"
+	ctx := mutex.WithOwner(context.Background(), "owner1")
+	if err := red.LockContext(ctx, key, time.Second); err != nil {
+		t.Errorf("no error expected for LockContext but got '%v'", err)
+	}
+	if err := red.LockContext(ctx, key, time.Second); err != nil {
+		t.Errorf("no error expected for reentrant LockContext but got '%v'", err)
+	}
+	if v := s.HGet(red.genKey(key), "owner1"); v != "2" {
+		t.Errorf("expected hold count 2 but got '%s'", v)
+	}
+	other := mutex.WithOwner(context.Background(), "owner2")
+	if err := red.LockContext(other, key, time.Second); err != mutex.ErrFail {
+		t.Errorf("expected error 'lock failed' but got '%v'", err)
+	}
+	if err := red.UnlockContext(other, key); err != mutex.ErrNotHeld {
+		t.Errorf("expected error 'lock not held' but got '%v'", err)
+	}
+	if err := red.UnlockContext(ctx, key); err != nil {
+		t.Errorf("no error expected for UnlockContext but got '%v'", err)
+	}
+	if !s.Exists(red.genKey(key)) {
+		t.Error("lock should be held until the final UnlockContext")
+	}
+	if err := red.UnlockContext(ctx, key); err != nil {
+		t.Errorf("no error expected for UnlockContext but got '%v'", err)
+	}
+	if s.Exists(red.genKey(key)) {
+		t.Error("lock should be released after the final UnlockContext")
+	}
+	if err := red.UnlockContext(ctx, key); err != mutex.ErrNotHeld {
+		t.Errorf("expected error 'lock not held' but got '%v'", err)
+	}
+}
+
+func TestReentrantLockExpiration(t *testing.T) {
+	red, s := newMiniredisMutex(t)
+	key := "key.test"
+	ctx := mutex.WithOwner(context.Background(), "owner1")
+	red.LockContext(ctx, key, 10*time.Second)
+	red.LockContext(ctx, key, time.Second)
+	if ttl := s.TTL(red.genKey(key)); ttl != 10*time.Second {
+		t.Errorf("nested lock should not shorten expiration but got %s", ttl)
+	}
+	red.LockContext(ctx, key, 20*time.Second)
+	if ttl := s.TTL(red.genKey(key)); ttl != 20*time.Second {
+		t.Errorf("nested lock should extend expiration but got %s", ttl)
+	}
+	s.FastForward(21 * time.Second)
+	if err := red.UnlockContext(ctx, key); err != mutex.ErrNotHeld {
+		t.Errorf("expected error 'lock not held' but got '%v'", err)
+	}
+	if err := red.LockContext(mutex.WithOwner(context.Background(), "owner2"), key, time.Second); err != nil {
+		t.Errorf("no error expected for LockContext on expired key but got '%v'", err)
+	}
+}
+
+func TestReentrantLockMixedWithLock(t *testing.T) {
+	red, _ := newMiniredisMutex(t)
+	key := "key.test"
+	ctx := mutex.WithOwner(context.Background(), "owner1")
+	if err := red.Lock(key, time.Second); err != nil {
+		t.Errorf("no error expected for Lock but got '%v'", err)
+	}
+	if err := red.LockContext(ctx, key, time.Second); err != mutex.ErrFail {
+		t.Errorf("expected error 'lock failed' but got '%v'", err)
+	}
+	if err := red.UnlockContext(ctx, key); err != mutex.ErrNotHeld {
+		t.Errorf("expected error 'lock not held' but got '%v'", err)
+	}
+	red.Unlock(key)
+	if err := red.LockContext(ctx, key, time.Second); err != nil {
+		t.Errorf("no error expected for LockContext but got '%v'", err)
+	}
+	if err := red.Lock(key, time.Second); err != mutex.ErrFail {
+		t.Errorf("expected error 'lock failed' but got '%v'", err)
+	}
+}
+
+func TestRenew(t *testing.T) {
+	red, s := newMiniredisMutex(t)
+	key := "key.test"
+	ctx := mutex.WithOwner(context.Background(), "owner1")
+	if err := red.RenewContext(ctx, key, time.Second); err != mutex.ErrNotHeld {
+		t.Errorf("expected error 'lock not held' but got '%v'", err)
+	}
+	red.LockContext(ctx, key, time.Second)
+	if err := red.RenewContext(ctx, key, time.Minute); err != nil {
+		t.Errorf("no error expected for RenewContext but got '%v'", err)
+	}
+	if ttl := s.TTL(red.genKey(key)); ttl != time.Minute {
+		t.Errorf("expected ttl 1m but got %s", ttl)
+	}
+	if v := s.HGet(red.genKey(key), "owner1"); v != "1" {
+		t.Errorf("hold count should not be changed by renewal but got '%s'", v)
+	}
+	if err := red.RenewContext(mutex.WithOwner(context.Background(), "owner2"), key, time.Minute); err != mutex.ErrNotHeld {
+		t.Errorf("expected error 'lock not held' but got '%v'", err)
+	}
+}
+
+func TestReentrantLockWithoutOwner(t *testing.T) {
+	conn, mock := redismock.NewClientMock()
+	red := &redisMutex{
+		conn: conn,
+	}
+	key := "key.test"
+	mock.ExpectSetNX(red.genKey(key), 1, time.Second).SetVal(true)
+	if err := red.LockContext(context.Background(), key, time.Second); err != nil {
+		t.Error("no error expected for LockContext")
+	}
+	mock.ExpectDel(red.genKey(key)).SetVal(1)
+	if err := red.UnlockContext(context.Background(), key); err != nil {
+		t.Error("no error expected for UnlockContext")
+	}
+}
+
+func TestClusterReentrantLock(t *testing.T) {
+	s := miniredis.RunT(t)
+	// multiple addrs select cluster mode
+	m := NewMutexWithOptions(&redis.UniversalOptions{Addrs: []string{s.Addr(), s.Addr()}})
+	t.Cleanup(func() { m.(*redisMutex).conn.Close() })
+	key := "key.test"
+	ctx := mutex.WithOwner(context.Background(), "owner1")
+	if err := m.LockContext(ctx, key, time.Minute); err != nil {
+		t.Fatalf("no error expected for lock but got '%v'", err)
+	}
+	if !s.Exists("__locker__:key.test") {
+		t.Errorf("key layout expected to be kept on cluster")
+	}
+	if err := m.UnlockContext(ctx, key); err != nil {
+		t.Errorf("no error expected for unlock but got '%v'", err)
+	}
+}
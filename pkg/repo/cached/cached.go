@@ -0,0 +1,147 @@
+package cached
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/cache"
+	"github.com/ofavor/ddd-go/pkg/entity"
+	"github.com/ofavor/ddd-go/pkg/log"
+	"github.com/ofavor/ddd-go/pkg/repo"
+	"github.com/ofavor/ddd-go/pkg/tx"
+)
+
+// Rehydrate entity from cached DAO
+type EntityLoader[E entity.Entity[D], D any] func(d *D) E
+
+// Get id of entity, it is used to invalidate cache after saving
+type IdGetter[E entity.Entity[D], D any] func(e E) interface{}
+
+// Cached repository option
+type Option func(o *options)
+
+type options struct {
+	deleteDelay time.Duration
+}
+
+// Delete cache again after delay since invalidation, 1s by default, zero disables it. See CachedRepo.
+func WithDeleteDelay(d time.Duration) Option {
+	return func(o *options) {
+		o.deleteDelay = d
+	}
+}
+
+// Cache-aside repository decorator, Get by id is cached and invalidated after Save/Delete is committed.
+// Reads inside a transaction bypass the cache.
+//
+// A read which misses the cache and loads the row before a concurrent Save commits could cache the stale row
+// after invalidation. The cache is deleted again after delete delay, so the stale row lives for the delay
+// instead of the TTL, unless the racing read takes longer than the delay to load and set the row.
+type CachedRepo[E entity.Entity[D], D any] struct {
+	repo        repo.Repository[E, D]
+	cache       cache.Cache
+	loader      EntityLoader[E, D]
+	idOf        IdGetter[E, D]
+	prefix      string
+	ttl         time.Duration
+	deleteDelay time.Duration
+}
+
+// Create cached repository, entities must implement entity.PersistSupport to be cached
+func NewRepo[E entity.Entity[D], D any](
+	r repo.Repository[E, D],
+	c cache.Cache,
+	loader EntityLoader[E, D],
+	idOf IdGetter[E, D],
+	prefix string,
+	ttl time.Duration,
+	opts ...Option,
+) *CachedRepo[E, D] {
+	o := &options{deleteDelay: time.Second}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &CachedRepo[E, D]{
+		repo:        r,
+		cache:       c,
+		loader:      loader,
+		idOf:        idOf,
+		prefix:      prefix,
+		ttl:         ttl,
+		deleteDelay: o.deleteDelay,
+	}
+}
+
+func (r *CachedRepo[E, D]) genKey(id interface{}) string {
+	return fmt.Sprintf("%s:%v", r.prefix, id)
+}
+
+// Invalidate cache after the transaction is committed, or immediately without transaction.
+// It is deleted again after delete delay to drop stale rows cached by racing reads.
+func (r *CachedRepo[E, D]) invalidate(t tx.Trans, id interface{}) {
+	tx.AfterCommit(t, func() {
+		r.del(id)
+		if r.deleteDelay > 0 {
+			time.AfterFunc(r.deleteDelay, func() { r.del(id) })
+		}
+	})
+}
+
+func (r *CachedRepo[E, D]) del(id interface{}) {
+	if err := r.cache.Del(context.Background(), r.genKey(id)); err != nil {
+		log.Warnf("[repo-cached] Got error while invalidating cache of %v: %v", id, err)
+	}
+}
+
+// Count implements repo.Repository.
+func (r *CachedRepo[E, D]) Count(tx tx.Trans, filter repo.Filter) (int64, error) {
+	return r.repo.Count(tx, filter)
+}
+
+// List implements repo.Repository.
+func (r *CachedRepo[E, D]) List(tx tx.Trans, filter repo.Filter, sorts []string, offset int64, limit int64) ([]E, error) {
+	return r.repo.List(tx, filter, sorts, offset, limit)
+}
+
+// Get implements repo.Repository.
+func (r *CachedRepo[E, D]) Get(tx tx.Trans, id interface{}) (e E, err error) {
+	if tx != nil {
+		return r.repo.Get(tx, id)
+	}
+	ctx := context.Background()
+	key := r.genKey(id)
+	d := new(D)
+	if err = r.cache.Get(ctx, key, d); err == nil {
+		return r.loader(d), nil
+	} else if err != cache.ErrNil {
+		log.Warnf("[repo-cached] Got error while getting cache of %v: %v", id, err)
+	}
+	if e, err = r.repo.Get(nil, id); err != nil {
+		return
+	}
+	if pe, ok := any(e).(entity.PersistSupport[D]); ok {
+		if err := r.cache.Set(ctx, key, pe.DAO(), r.ttl); err != nil {
+			log.Warnf("[repo-cached] Got error while setting cache of %v: %v", id, err)
+		}
+	}
+	return e, nil
+}
+
+// Save implements repo.Repository.
+func (r *CachedRepo[E, D]) Save(tx tx.Trans, e E) error {
+	if err := r.repo.Save(tx, e); err != nil {
+		return err
+	}
+	r.invalidate(tx, r.idOf(e))
+	return nil
+}
+
+// Delete implements repo.Repository.
+func (r *CachedRepo[E, D]) Delete(tx tx.Trans, id interface{}) error {
+	if err := r.repo.Delete(tx, id); err != nil {
+		return err
+	}
+	r.invalidate(tx, id)
+	return nil
+}
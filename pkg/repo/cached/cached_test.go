@@ -0,0 +1,147 @@
+package cached
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ofavor/ddd-go/pkg/cache"
+	"github.com/ofavor/ddd-go/pkg/cache/memory"
+	repogorm "github.com/ofavor/ddd-go/pkg/repo/gorm"
+	"github.com/ofavor/ddd-go/pkg/tx"
+	txgorm "github.com/ofavor/ddd-go/pkg/tx/gorm"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type userDao struct {
+	ID   uint `gorm:"primaryKey"`
+	Name string
+}
+
+type user struct {
+	dao *userDao
+}
+
+func (u *user) IsNew() bool {
+	return u.dao.ID == 0
+}
+
+func (u *user) DAO() *userDao {
+	return u.dao
+}
+
+func loadUser(d *userDao) *user {
+	return &user{dao: d}
+}
+
+func newTestRepo(t *testing.T) (*CachedRepo[*user, userDao], *repogorm.GormRepo[*user, userDao], cache.Cache, tx.TransMgr) {
+	conn, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, _ := conn.DB()
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	conn.AutoMigrate(&userDao{})
+	inner := repogorm.NewRepo(conn, loadUser)
+	c := memory.NewCache(0)
+	r := NewRepo[*user, userDao](inner, c, loadUser, func(u *user) interface{} { return u.dao.ID }, "user", time.Minute)
+	return r, inner, c, txgorm.NewTransMgr(conn)
+}
+
+func TestGetCached(t *testing.T) {
+	r, inner, c, _ := newTestRepo(t)
+	u := loadUser(&userDao{Name: "test"})
+	if err := r.Save(nil, u); err != nil {
+		t.Fatalf("no error expected for Save but got '%v'", err)
+	}
+	if _, err := r.Get(nil, u.dao.ID); err != nil {
+		t.Fatalf("no error expected for Get but got '%v'", err)
+	}
+	d := userDao{}
+	if err := c.Get(context.Background(), "user:1", &d); err != nil || d.Name != "test" {
+		t.Errorf("DAO should be cached but got '%v'", err)
+	}
+	// change database directly, cached value is returned
+	inner.GetConn(nil).Model(&userDao{}).Where("id = ?", 1).Update("name", "changed")
+	got, err := r.Get(nil, uint(1))
+	if err != nil || got.dao.Name != "test" {
+		t.Errorf("expected cached name 'test' but got '%s', '%v'", got.dao.Name, err)
+	}
+}
+
+func TestInvalidateAfterCommit(t *testing.T) {
+	r, _, c, tm := newTestRepo(t)
+	ctx := context.Background()
+	u := loadUser(&userDao{Name: "test"})
+	r.Save(nil, u)
+	r.Get(nil, u.dao.ID)
+
+	tm.Transaction(func(t1 tx.Trans) error {
+		u.dao.Name = "changed"
+		if err := r.Save(t1, u); err != nil {
+			t.Errorf("no error expected for Save but got '%v'", err)
+		}
+		if n, _ := c.(cache.ExtendedCache).Exists(ctx, "user:1"); n != 1 {
+			t.Error("cache should not be invalidated before commit")
+		}
+		got, _ := r.Get(t1, u.dao.ID)
+		if got.dao.Name != "changed" {
+			t.Errorf("read in transaction should bypass cache but got '%s'", got.dao.Name)
+		}
+		return nil
+	})
+	if n, _ := c.(cache.ExtendedCache).Exists(ctx, "user:1"); n != 0 {
+		t.Error("cache should be invalidated after commit")
+	}
+	got, _ := r.Get(nil, u.dao.ID)
+	if got.dao.Name != "changed" {
+		t.Errorf("expected 'changed' but got '%s'", got.dao.Name)
+	}
+}
+
+func TestKeepCacheAfterRollback(t *testing.T) {
+	r, _, c, tm := newTestRepo(t)
+	ctx := context.Background()
+	u := loadUser(&userDao{Name: "test"})
+	r.Save(nil, u)
+	r.Get(nil, u.dao.ID)
+	err := tm.Transaction(func(t1 tx.Trans) error {
+		r.Delete(t1, u.dao.ID)
+		return errors.New("some error")
+	})
+	if err == nil {
+		t.Error("error expected for Transaction")
+	}
+	if n, _ := c.(cache.ExtendedCache).Exists(ctx, "user:1"); n != 1 {
+		t.Error("cache should be kept after rollback")
+	}
+	r.Delete(nil, u.dao.ID)
+	if _, err := r.Get(nil, u.dao.ID); err == nil {
+		t.Error("error expected for Get after Delete")
+	}
+}
+
+func TestDelayedDelete(t *testing.T) {
+	r, _, c, _ := newTestRepo(t)
+	r.deleteDelay = 20 * time.Millisecond
+	ctx := context.Background()
+	u := loadUser(&userDao{Name: "test"})
+	r.Save(nil, u)
+	stale := *u.dao
+	u.dao.Name = "changed"
+	r.Save(nil, u)
+	// a racing read caches the row loaded before Save
+	c.Set(ctx, "user:1", &stale, time.Minute)
+	time.Sleep(50 * time.Millisecond)
+	got, _ := r.Get(nil, u.dao.ID)
+	if got.dao.Name != "changed" {
+		t.Errorf("stale row should be deleted after delay but got '%s'", got.dao.Name)
+	}
+}
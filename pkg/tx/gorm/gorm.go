@@ -8,13 +8,19 @@ import (
 
 // trans implementation based on gorm
 type gormTrans struct {
-	conn *gorm.DB
+	conn  *gorm.DB
+	hooks []func()
 }
 
 func (t *gormTrans) GetPrincipal() interface{} {
 	return t.conn
 }
 
+// AfterCommit implements tx.CommitHooks.
+func (t *gormTrans) AfterCommit(f func()) {
+	t.hooks = append(t.hooks, f)
+}
+
 type gormTransMgr struct {
 	conn *gorm.DB
 }
@@ -29,8 +35,16 @@ func (tm *gormTransMgr) Transaction(f tx.TransFunc) error {
 	if tm.conn == nil {
 		panic("[trans-gorm] Failed to start transaction, no database connection")
 	}
+	t := &gormTrans{}
 	dummy := func(tx *gorm.DB) error {
-		return f(&gormTrans{conn: tx})
+		t.conn = tx
+		return f(t)
+	}
+	if err := tm.conn.Transaction(dummy); err != nil {
+		return err
+	}
+	for _, h := range t.hooks {
+		h()
 	}
-	return tm.conn.Transaction(dummy)
+	return nil
 }
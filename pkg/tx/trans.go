@@ -1,11 +1,29 @@
 package tx
 
+import "context"
+
 // Transaction interface
 type Trans interface {
 	// Get the underlying transction instance
 	GetPrincipal() interface{}
 }
 
+// Transaction supporting callbacks after commit
+type CommitHooks interface {
+	// Register a callback which is called after the transaction is committed
+	AfterCommit(f func())
+}
+
+// Run f after the transaction is committed. It runs immediately if there is no transaction
+// or the transaction does not support commit hooks.
+func AfterCommit(t Trans, f func()) {
+	if h, ok := t.(CommitHooks); ok {
+		h.AfterCommit(f)
+		return
+	}
+	f()
+}
+
 // Transaction callback function. return nil to commit the transaction, error to rollback the transaction
 type TransFunc func(tx Trans) error
 
@@ -13,3 +31,16 @@ type TransFunc func(tx Trans) error
 type TransMgr interface {
 	Transaction(f TransFunc) error
 }
+
+type transKey struct{}
+
+// Add transaction to context, e.g. the inbox transaction of an event handler
+func NewContext(ctx context.Context, t Trans) context.Context {
+	return context.WithValue(ctx, transKey{}, t)
+}
+
+// Get transaction from context, nil if there is none
+func FromContext(ctx context.Context) Trans {
+	t, _ := ctx.Value(transKey{}).(Trans)
+	return t
+}